@@ -0,0 +1,122 @@
+package getcached
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LogFormat is the format of the records of an AccessLog.
+type LogFormat int
+
+// Formats of the records of an AccessLog.
+const (
+	JSONLog     LogFormat = iota // one JSON object per line
+	CommonLog                    // NCSA Common Log Format
+	CombinedLog                  // NCSA Combined Log Format
+)
+
+const commonTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog writes a record of the requests served by a
+// Proxy. It is safe for concurrent use.
+type AccessLog struct {
+	w      io.Writer
+	format LogFormat
+	rate   float64 // fraction of the requests logged
+	mu     sync.Mutex
+}
+
+type accessRecord struct {
+	Time      time.Time   `json:"time"`
+	Client    string      `json:"client"`
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Proto     string      `json:"proto"`
+	Status    int         `json:"status"`
+	Bytes     int64       `json:"bytes"`
+	Duration  float64     `json:"duration_ms"`
+	Cache     CacheStatus `json:"cache"`
+	Referer   string      `json:"referer,omitempty"`
+	UserAgent string      `json:"user_agent,omitempty"`
+}
+
+// NewAccessLog creates an AccessLog writing to w.
+func NewAccessLog(w io.Writer, options ...func(*AccessLog)) *AccessLog {
+	l := &AccessLog{
+		w:      w,
+		format: JSONLog,
+		rate:   1,
+	}
+
+	for _, option := range options {
+		option(l)
+	}
+
+	return l
+}
+
+// WithLogFormat configures the format of the records of
+// an AccessLog.
+func WithLogFormat(f LogFormat) func(*AccessLog) {
+	return func(l *AccessLog) {
+		l.format = f
+	}
+}
+
+// WithLogSampling configures an AccessLog to only record
+// a random fraction of the requests, between 0 and 1.
+func WithLogSampling(rate float64) func(*AccessLog) {
+	return func(l *AccessLog) {
+		l.rate = rate
+	}
+}
+
+// log records a request served by a Proxy.
+func (l *AccessLog) log(req *http.Request, w *responseRecorder, e *Exchange, start time.Time) {
+	if l.rate < 1 && rand.Float64() >= l.rate {
+		return
+	}
+
+	r := accessRecord{
+		Time:      start,
+		Client:    req.RemoteAddr,
+		Method:    req.Method,
+		URL:       req.URL.RequestURI(),
+		Proto:     req.Proto,
+		Status:    w.Status(),
+		Bytes:     w.bytes,
+		Duration:  float64(time.Since(start)) / float64(time.Millisecond),
+		Cache:     e.CacheStatus(),
+		Referer:   req.Referer(),
+		UserAgent: req.UserAgent(),
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		r.Client = host
+	}
+	if e.Origin != nil {
+		r.URL = e.Origin.String()
+	}
+
+	buf := new(bytes.Buffer)
+	switch l.format {
+	case CommonLog, CombinedLog:
+		fmt.Fprintf(buf, "%s - - [%s] %q %d %d", r.Client, r.Time.Format(commonTimeFormat), r.Method+" "+r.URL+" "+r.Proto, r.Status, r.Bytes)
+		if l.format == CombinedLog {
+			fmt.Fprintf(buf, " %q %q", r.Referer, r.UserAgent)
+		}
+		buf.WriteByte('\n')
+	default:
+		json.NewEncoder(buf).Encode(r)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(buf.Bytes())
+}
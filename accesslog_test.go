@@ -0,0 +1,78 @@
+package getcached
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=3600")
+		rw.Write([]byte("content"))
+	}))
+	defer origin.Close()
+
+	tests := []struct {
+		format LogFormat
+		want   *regexp.Regexp
+	}{
+		{CommonLog, regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET http://127\.0\.0\.1:\d+/resource HTTP/1\.1" 200 7\n$`)},
+		{CombinedLog, regexp.MustCompile(`^192\.0\.2\.1 - - \[.+\] "GET http://127\.0\.0\.1:\d+/resource HTTP/1\.1" 200 7 "http://referer\.net/" "agent"\n$`)},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		p := New(WithAccessLog(NewAccessLog(buf, WithLogFormat(test.format))))
+		p.ServeHTTP(httptest.NewRecorder(), request(origin.URL+"/resource"))
+
+		if !test.want.Match(buf.Bytes()) {
+			t.Errorf("unexpected record: got %q, want match of %s", buf, test.want)
+		}
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=3600")
+		rw.Write([]byte("content"))
+	}))
+	defer origin.Close()
+
+	buf := new(bytes.Buffer)
+	p := New(WithAccessLog(NewAccessLog(buf)))
+	p.ServeHTTP(httptest.NewRecorder(), request(origin.URL+"/resource"))
+	p.ServeHTTP(httptest.NewRecorder(), request(origin.URL+"/resource"))
+
+	dec := json.NewDecoder(buf)
+	for _, want := range []CacheStatus{StatusMiss, StatusHit} {
+		var r accessRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("unexpected error: %q", err)
+		}
+		if r.Cache != want || r.Status != http.StatusOK || r.Bytes != 7 || r.URL != origin.URL+"/resource" || r.Client != "192.0.2.1" {
+			t.Errorf("unexpected record: %+v", r)
+		}
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p := New(WithAccessLog(NewAccessLog(buf, WithLogSampling(0))))
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if buf.Len() != 0 {
+		t.Errorf("unexpected record: %q", buf)
+	}
+}
+
+func request(origin string) *http.Request {
+	req := httptest.NewRequest("GET", "/?q="+url.QueryEscape(origin), nil)
+	req.Header.Set("Referer", "http://referer.net/")
+	req.Header.Set("User-Agent", "agent")
+	return req
+}
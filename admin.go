@@ -0,0 +1,310 @@
+package getcached
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/freshness"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Admin is an http.Handler answering operators about the
+// entries of the tiers of a Proxy:
+//
+//	GET /entry?url=<url>
+//		describes the response cached for url in every tier
+//		holding it, and the proxy owning url on the ring
+//	GET /keys/<tier>?prefix=<prefix>&after=<key>&limit=<n>
+//		lists the keys of a tier in order, by pages whose
+//		last key is passed as after to get the next one
+//	POST /resize/<tier>?size=<bytes>
+//		changes the capacity of a tier implementing Resizer,
+//		size being positive and below math.MaxInt64
+//
+// Looking up an entry refreshes it in caches such as
+// lru.Cache, but doesn't count in the Stats of a Monitor.
+type Admin struct {
+	tiers  Tiers
+	picker Picker
+	mux    *http.ServeMux
+}
+
+// NewAdmin creates an Admin inspecting tiers.
+func NewAdmin(tiers Tiers, options ...func(*Admin)) *Admin {
+	a := &Admin{tiers: tiers, mux: http.NewServeMux()}
+
+	for _, option := range options {
+		option(a)
+	}
+
+	a.mux.HandleFunc("/entry", allow(http.MethodGet, a.entry))
+	a.mux.HandleFunc("/keys/", allow(http.MethodGet, a.keys))
+	a.mux.HandleFunc("/resize/", allow(http.MethodPost, a.resize))
+
+	return a
+}
+
+// WithAdminPicker configures an Admin to tell which proxy
+// of the fleet owns a URL, as picked by p.
+func WithAdminPicker(p Picker) func(*Admin) {
+	return func(a *Admin) {
+		a.picker = p
+	}
+}
+
+// Resizer is implemented by caches whose capacity can change
+// while in use, such as lru.Cache.
+type Resizer interface {
+	Resize(size uint64)
+}
+
+// ServeHTTP implements http.Handler.
+func (a *Admin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	a.mux.ServeHTTP(rw, req)
+}
+
+// allow restricts a handler to a method.
+func allow(method string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			rw.Header().Set("Allow", method)
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		fn(rw, req)
+	}
+}
+
+type (
+	entryInfo struct {
+		Key   string      `json:"key"`
+		Owner string      `json:"owner,omitempty"`
+		Tiers []tierEntry `json:"tiers"`
+	}
+
+	tierEntry struct {
+		Tier       string      `json:"tier"`
+		Size       int         `json:"size"`
+		Status     int         `json:"status,omitempty"`
+		StoredAt   *time.Time  `json:"stored_at,omitempty"` // from the Date and Age headers
+		Expires    *time.Time  `json:"expires,omitempty"`
+		TTL        *int64      `json:"ttl_seconds,omitempty"` // negative once stale
+		StaleUntil *time.Time  `json:"stale_until,omitempty"`
+		Header     http.Header `json:"header,omitempty"`
+		Variants   []string    `json:"variants,omitempty"` // keys of the variants of a response varying
+	}
+
+	keyInfo struct {
+		Key  string `json:"key"`
+		Size int64  `json:"size"`
+	}
+
+	keyPage struct {
+		Keys []keyInfo `json:"keys"`
+		Next string    `json:"next,omitempty"` // after of the next page, empty on the last one
+	}
+
+	tierSize struct {
+		Tier     string `json:"tier"`
+		Capacity int64  `json:"capacity"`
+		Used     int64  `json:"used"`
+		Items    int    `json:"items"`
+	}
+)
+
+func (a *Admin) entry(rw http.ResponseWriter, req *http.Request) {
+	key := req.URL.Query().Get("url")
+	if key == "" {
+		http.Error(rw, "missing url parameter", http.StatusBadRequest)
+		return
+	}
+
+	info := entryInfo{Key: key, Tiers: []tierEntry{}}
+	if a.picker != nil {
+		info.Owner = a.picker.Pick(key)
+	}
+
+	for _, t := range a.tiers {
+		c := t.Cache
+		if m, ok := c.(*Monitor); ok {
+			c = m.Unwrap()
+		}
+		if resp, ok := c.Get(key); ok {
+			info.Tiers = append(info.Tiers, describe(t.Name, resp))
+		}
+	}
+
+	status := http.StatusOK
+	if len(info.Tiers) == 0 {
+		status = http.StatusNotFound
+	}
+	writeJSON(rw, status, info)
+}
+
+// describe describes a cached response, or the variants
+// of a response when resp is their index.
+func describe(tier string, resp []byte) tierEntry {
+	e := tierEntry{Tier: tier, Size: len(resp)}
+
+	if idx, ok := parseIndex(resp); ok {
+		e.Variants = idx.keys
+		return e
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+	if err != nil {
+		return e
+	}
+	e.Status = res.StatusCode
+	e.Header = res.Header
+
+	if date, err := http.ParseTime(res.Header.Get("Date")); err == nil {
+		age, _ := strconv.Atoi(res.Header.Get("Age"))
+		stored := date.Add(time.Duration(age) * time.Second)
+		e.StoredAt = &stored
+	}
+	if f, ok := freshness.FromHeader(res.Header); ok {
+		expires, staleUntil := f.Expires(), f.StaleUntil()
+		ttl := int64(time.Until(expires) / time.Second)
+		e.Expires, e.StaleUntil, e.TTL = &expires, &staleUntil, &ttl
+	}
+
+	return e
+}
+
+func (a *Admin) keys(rw http.ResponseWriter, req *http.Request) {
+	c, ok := a.tier(rw, strings.TrimPrefix(req.URL.Path, "/keys/"))
+	if !ok {
+		return
+	}
+
+	r, ok := Find(c, func(c httpcache.Cache) bool {
+		_, ok := c.(ranger)
+		return ok
+	}).(ranger)
+	if !ok {
+		http.Error(rw, "tier entries can't be enumerated", http.StatusNotImplemented)
+		return
+	}
+
+	q := req.URL.Query()
+	prefix, after := q.Get("prefix"), q.Get("after")
+	limit := defaultPageSize
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			http.Error(rw, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	// keep the first limit+1 keys only, the extra one
+	// telling whether there is a next page
+	h := &keyHeap{}
+	r.Range(func(key string, size int64) {
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			return
+		}
+		if h.Len() > limit {
+			if key >= (*h)[0].Key {
+				return
+			}
+			heap.Pop(h)
+		}
+		heap.Push(h, keyInfo{Key: key, Size: size})
+	})
+	keys := []keyInfo(*h)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+
+	page := keyPage{Keys: keys}
+	if len(keys) > limit {
+		page.Keys = keys[:limit]
+		page.Next = keys[limit-1].Key
+	}
+	writeJSON(rw, http.StatusOK, page)
+}
+
+func (a *Admin) resize(rw http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/resize/")
+	c, ok := a.tier(rw, name)
+	if !ok {
+		return
+	}
+
+	size, err := strconv.ParseUint(req.URL.Query().Get("size"), 10, 63)
+	if err != nil || size == 0 || size >= math.MaxInt64 {
+		http.Error(rw, "invalid size parameter", http.StatusBadRequest)
+		return
+	}
+
+	r, ok := Find(c, func(c httpcache.Cache) bool {
+		_, ok := c.(Resizer)
+		return ok
+	}).(Resizer)
+	if !ok {
+		http.Error(rw, "tier can't be resized", http.StatusNotImplemented)
+		return
+	}
+
+	r.Resize(size)
+	res := tierSize{Tier: name}
+	if sz, ok := r.(Sizer); ok {
+		res.Capacity, res.Used, res.Items = sz.Capacity(), sz.Used(), sz.Len()
+	}
+	writeJSON(rw, http.StatusOK, res)
+}
+
+// tier returns the cache of the tier named name, or replies
+// with an error if there is none.
+func (a *Admin) tier(rw http.ResponseWriter, name string) (httpcache.Cache, bool) {
+	for _, t := range a.tiers {
+		if t.Name == name {
+			return t.Cache, true
+		}
+	}
+	http.Error(rw, "unknown tier "+strconv.Quote(name), http.StatusNotFound)
+	return nil, false
+}
+
+// ranger is implemented by caches enumerating their entries,
+// such as lru.Cache.
+type ranger interface {
+	Range(fn func(key string, size int64))
+}
+
+// keyHeap is a max-heap of keys, its greatest key first.
+type keyHeap []keyInfo
+
+func (h keyHeap) Len() int            { return len(h) }
+func (h keyHeap) Less(i, j int) bool  { return h[i].Key > h[j].Key }
+func (h keyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x interface{}) { *h = append(*h, x.(keyInfo)) }
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
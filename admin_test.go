@@ -0,0 +1,198 @@
+package getcached
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/lru"
+	"github.com/mikegleasonjr/getcached/mocks"
+)
+
+func TestAdminEntry(t *testing.T) {
+	memory, disk := lru.New(), lru.New()
+	picker := new(mocks.Picker)
+	picker.On("Pick", "http://origin/a").Return("http://proxy2")
+	picker.On("Pick", "http://origin/b").Return("http://proxy1")
+	monitor := NewMonitor(disk)
+	admin := NewAdmin(Tiers{{"memory", NewMonitor(memory)}, {"disk", monitor}}, WithAdminPicker(picker))
+
+	date := time.Now().UTC().Truncate(time.Second)
+	resp := "HTTP/1.1 200 OK\r\nDate: " + date.Format(http.TimeFormat) + "\r\nAge: 10\r\nCache-Control: max-age=60, stale-if-error=30\r\n\r\nbody"
+	disk.Set("http://origin/a", []byte(resp))
+
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("GET", "/entry?url="+url.QueryEscape("http://origin/a"), nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	var info entryInfo
+	if err := json.NewDecoder(rr.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Owner != "http://proxy2" {
+		t.Errorf("unexpected owner: got %q, want %q", info.Owner, "http://proxy2")
+	}
+	if len(info.Tiers) != 1 {
+		t.Fatalf("unexpected tiers: got %d, want %d", len(info.Tiers), 1)
+	}
+	e := info.Tiers[0]
+	if e.Tier != "disk" || e.Size != len(resp) || e.Status != http.StatusOK {
+		t.Errorf("unexpected entry: got %s/%d/%d, want %s/%d/%d", e.Tier, e.Size, e.Status, "disk", len(resp), http.StatusOK)
+	}
+	if want := date.Add(10 * time.Second); e.StoredAt == nil || !e.StoredAt.Equal(want) {
+		t.Errorf("unexpected stored at: got %v, want %v", e.StoredAt, want)
+	}
+	if want := date.Add(80 * time.Second); e.StaleUntil == nil || !e.StaleUntil.Equal(want) {
+		t.Errorf("unexpected stale until: got %v, want %v", e.StaleUntil, want)
+	}
+	if e.TTL == nil || *e.TTL < 48 || *e.TTL > 50 {
+		t.Errorf("unexpected ttl: got %v, want about %d", e.TTL, 50)
+	}
+	if got := e.Header.Get("Cache-Control"); got != "max-age=60, stale-if-error=30" {
+		t.Errorf("unexpected header: got %q", got)
+	}
+	if gets := monitor.Stats().Gets; gets != 0 {
+		t.Errorf("unexpected monitored gets: got %d, want %d", gets, 0)
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("GET", "/entry?url="+url.QueryEscape("http://origin/b"), nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unexpected status code of a missing entry: got %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminEntryVariants(t *testing.T) {
+	memory := httpcache.NewMemoryCache()
+	memory.Set("http://origin/a", index{vary: []string{"Accept-Language"}, keys: []string{"k1", "k2"}}.bytes())
+	admin := NewAdmin(Tiers{{"memory", memory}})
+
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("GET", "/entry?url="+url.QueryEscape("http://origin/a"), nil))
+
+	var info entryInfo
+	json.NewDecoder(rr.Body).Decode(&info)
+	if len(info.Tiers) != 1 || !cmp.Equal(info.Tiers[0].Variants, []string{"k1", "k2"}) {
+		t.Errorf("unexpected variants: got %+v, want %v", info.Tiers, []string{"k1", "k2"})
+	}
+	if info.Owner != "" {
+		t.Errorf("unexpected owner without picker: got %q", info.Owner)
+	}
+}
+
+func TestAdminKeys(t *testing.T) {
+	memory := lru.New()
+	for _, k := range []string{"http://b/3", "http://a/1", "http://b/1", "http://a/2", "http://b/2"} {
+		memory.Set(k, []byte("value"))
+	}
+	admin := NewAdmin(Tiers{{"memory", NewMonitor(memory)}, {"remote", httpcache.NewMemoryCache()}})
+
+	tests := []struct {
+		query string
+		code  int
+		keys  []string
+		next  string
+	}{
+		{"/keys/memory", http.StatusOK, []string{"http://a/1", "http://a/2", "http://b/1", "http://b/2", "http://b/3"}, ""},
+		{"/keys/memory?limit=2", http.StatusOK, []string{"http://a/1", "http://a/2"}, "http://a/2"},
+		{"/keys/memory?limit=2&after=http://a/2", http.StatusOK, []string{"http://b/1", "http://b/2"}, "http://b/2"},
+		{"/keys/memory?limit=2&after=http://b/2", http.StatusOK, []string{"http://b/3"}, ""},
+		{"/keys/memory?prefix=http://b/&limit=2", http.StatusOK, []string{"http://b/1", "http://b/2"}, "http://b/2"},
+		{"/keys/memory?limit=1&after=http://a/1", http.StatusOK, []string{"http://a/2"}, "http://a/2"},
+		{"/keys/memory?limit=0", http.StatusBadRequest, nil, ""},
+		{"/keys/remote", http.StatusNotImplemented, nil, ""},
+		{"/keys/disk", http.StatusNotFound, nil, ""},
+	}
+
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, httptest.NewRequest("GET", test.query, nil))
+		if rr.Code != test.code {
+			t.Errorf("unexpected status code for %s: got %d, want %d", test.query, rr.Code, test.code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		var page keyPage
+		json.NewDecoder(rr.Body).Decode(&page)
+		keys := []string{}
+		for _, k := range page.Keys {
+			keys = append(keys, k.Key)
+		}
+		if !cmp.Equal(keys, test.keys) || page.Next != test.next {
+			t.Errorf("unexpected page for %s: got %v next %q, want %v next %q", test.query, keys, page.Next, test.keys, test.next)
+		}
+	}
+}
+
+func TestAdminResize(t *testing.T) {
+	memory := lru.New(lru.WithSize(12))
+	for _, k := range []string{"key1", "key2", "key3"} {
+		memory.Set(k, []byte("four"))
+	}
+	admin := NewAdmin(Tiers{{"memory", NewMonitor(memory)}, {"remote", httpcache.NewMemoryCache()}})
+
+	tests := []struct {
+		query string
+		code  int
+		want  tierSize
+	}{
+		{"/resize/memory?size=8", http.StatusOK, tierSize{Tier: "memory", Capacity: 8, Used: 8, Items: 2}},
+		{"/resize/memory?size=100", http.StatusOK, tierSize{Tier: "memory", Capacity: 100, Used: 8, Items: 2}},
+		{"/resize/memory?size=-1", http.StatusBadRequest, tierSize{}},
+		{"/resize/memory?size=0", http.StatusBadRequest, tierSize{}},
+		{"/resize/memory?size=9223372036854775807", http.StatusBadRequest, tierSize{}},
+		{"/resize/memory?size=9223372036854775808", http.StatusBadRequest, tierSize{}},
+		{"/resize/memory?size=lots", http.StatusBadRequest, tierSize{}},
+		{"/resize/memory", http.StatusBadRequest, tierSize{}},
+		{"/resize/remote?size=8", http.StatusNotImplemented, tierSize{}},
+		{"/resize/disk?size=8", http.StatusNotFound, tierSize{}},
+	}
+
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, httptest.NewRequest("POST", test.query, nil))
+		if rr.Code != test.code {
+			t.Errorf("unexpected status code for %s: got %d, want %d", test.query, rr.Code, test.code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		var got tierSize
+		json.NewDecoder(rr.Body).Decode(&got)
+		if got != test.want {
+			t.Errorf("unexpected size for %s: got %+v, want %+v", test.query, got, test.want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("GET", "/resize/memory?size=8", nil))
+	if got := fmt.Sprint(rr.Header()["Allow"]); rr.Code != http.StatusMethodNotAllowed || got != "[POST]" {
+		t.Errorf("unexpected reply to GET: got %d allowing %s, want %d allowing %s", rr.Code, got, http.StatusMethodNotAllowed, "[POST]")
+	}
+}
+
+func TestAdminMethod(t *testing.T) {
+	admin := NewAdmin(Tiers{})
+
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("DELETE", "/entry?url=x", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status code: got %d, want %d", rr.Code, http.StatusMethodNotAllowed)
+	}
+	if got := fmt.Sprint(rr.Header()["Allow"]); got != "[GET]" {
+		t.Errorf("unexpected allowed methods: got %s, want %s", got, "[GET]")
+	}
+}
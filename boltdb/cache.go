@@ -0,0 +1,145 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	defaultPath = path.Join(os.TempDir(), "getcached.db")
+	bucket      = []byte("responses")
+	writes      = []byte("writes")    // write sequence to key
+	sequences   = []byte("sequences") // key to write sequence
+)
+
+// Cache caches requests in a single bolt database file,
+// remembering the order they were stored in.
+type Cache struct {
+	path string
+	db   *bolt.DB
+}
+
+// New creates a Cache backed by a database file, creating
+// it if needed. Panics if the database can't be opened.
+func New(options ...func(*Cache)) *Cache {
+	c := &Cache{path: defaultPath}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	db, err := bolt.Open(c.path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucket, writes, sequences} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		panic(err)
+	}
+
+	c.db = db
+
+	return c
+}
+
+// Get gets an item from the cache.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	c.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get([]byte(key)); v != nil {
+			resp = append([]byte(nil), v...) // only valid during the transaction
+			ok = true
+		}
+		return nil
+	})
+	return
+}
+
+// Set saves a response to the cache as key.
+func (c *Cache) Set(key string, resp []byte) {
+	c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucket).Put([]byte(key), resp); err != nil {
+			return err
+		}
+		return record(tx, []byte(key))
+	})
+}
+
+// Delete deletes an item from the cache.
+func (c *Cache) Delete(key string) {
+	c.db.Update(func(tx *bolt.Tx) error {
+		if err := forget(tx, []byte(key)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+// Range calls fn with the key and size of every value of the
+// cache, from the least to the most recently stored.
+func (c *Cache) Range(fn func(key string, size int64)) {
+	c.db.View(func(tx *bolt.Tx) error {
+		responses := tx.Bucket(bucket)
+		return tx.Bucket(writes).ForEach(func(_, k []byte) error {
+			if v := responses.Get(k); v != nil {
+				fn(string(k), int64(len(v)))
+			}
+			return nil
+		})
+	})
+}
+
+// Close closes the database.
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// record makes key the most recently stored.
+func record(tx *bolt.Tx, key []byte) error {
+	if err := forget(tx, key); err != nil {
+		return err
+	}
+
+	seq, err := tx.Bucket(writes).NextSequence()
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+
+	if err := tx.Bucket(writes).Put(b, key); err != nil {
+		return err
+	}
+	return tx.Bucket(sequences).Put(key, b)
+}
+
+// forget removes the write sequence of key.
+func forget(tx *bolt.Tx, key []byte) error {
+	seq := tx.Bucket(sequences).Get(key)
+	if seq == nil {
+		return nil
+	}
+	if err := tx.Bucket(writes).Delete(seq); err != nil {
+		return err
+	}
+	return tx.Bucket(sequences).Delete(key)
+}
+
+// WithPath sets the database file.
+func WithPath(path string) func(*Cache) {
+	return func(c *Cache) {
+		c.path = path
+	}
+}
@@ -0,0 +1,92 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+	"github.com/gregjones/httpcache/test"
+	"github.com/mikegleasonjr/getcached/cachetest"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltdb")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	c := New(WithPath(path.Join(dir, "cache.db")))
+	defer c.Close()
+	test.Cache(t, c)
+}
+
+func TestReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltdb")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "cache.db")
+
+	c := New(WithPath(file))
+	c.Set("key1", []byte("value1"))
+	c.Set("key2", []byte("value2"))
+	c.Delete("key2")
+	c.Close()
+
+	c = New(WithPath(file))
+	defer c.Close()
+
+	if got, ok := c.Get("key1"); !ok || string(got) != "value1" {
+		t.Errorf("unexpected value for %q: got %q (%t), want %q", "key1", got, ok, "value1")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Errorf("unexpected value for %q", "key2")
+	}
+
+	sizes := map[string]int64{}
+	c.Range(func(key string, size int64) { sizes[key] = size })
+	if len(sizes) != 1 || sizes["key1"] != 6 {
+		t.Errorf("unexpected range: %v", sizes)
+	}
+}
+
+func TestRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltdb")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "cache.db")
+
+	c := New(WithPath(file))
+	c.Set("key2", []byte("value"))
+	c.Set("key4", []byte("value"))
+	c.Set("key3", []byte("value"))
+	c.Set("key1", []byte("value"))
+	c.Set("key5", []byte("value"))
+	c.Delete("key5")
+	c.Set("key2", []byte("value"))
+	c.Close()
+
+	c = New(WithPath(file))
+	defer c.Close()
+
+	keys := []string{}
+	c.Range(func(key string, size int64) { keys = append(keys, key) })
+	if got, want := strings.Join(keys, ","), "key4,key3,key1,key2"; got != want {
+		t.Errorf("unexpected range: got %s, want %s", got, want)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltdb")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	cachetest.RunConformance(t, func() httpcache.Cache { return New(WithPath(path.Join(dir, "cache.db"))) })
+}
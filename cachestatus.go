@@ -0,0 +1,147 @@
+package getcached
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/freshness"
+)
+
+// CacheStatusHeader is the header describing how caches
+// handled a request (RFC 9211).
+const CacheStatusHeader = "Cache-Status"
+
+// cacheStatus adds the entry of a Proxy to the Cache-Status
+// header of its responses.
+type cacheStatus struct {
+	node     string       // name of the proxy in the entries
+	internal []*net.IPNet // networks of the clients seeing the header, all when empty
+}
+
+// apply adds the entry describing an exchange to the header
+// of its response, or removes the header if the client is
+// external.
+func (cs *cacheStatus) apply(h http.Header, req *http.Request, e *Exchange) {
+	if !cs.isInternal(req.RemoteAddr) {
+		h.Del(CacheStatusHeader)
+		return
+	}
+	if e.Origin != nil {
+		h.Add(CacheStatusHeader, cs.entry(h, req, e))
+	}
+}
+
+func (cs *cacheStatus) isInternal(addr string) bool {
+	if len(cs.internal) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	for _, n := range cs.internal {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// entry describes how an exchange was handled, naming the
+// tier of cached responses after the node.
+func (cs *cacheStatus) entry(h http.Header, req *http.Request, e *Exchange) string {
+	fromCache := h.Get(httpcache.XFromCache) == "1"
+
+	name := cs.node
+	if fromCache && e.Tier != "" {
+		name += "/" + e.Tier
+	}
+	params := []string{item(name)}
+
+	switch {
+	case e.Fetches == 0 && fromCache:
+		params = append(params, "hit")
+	case e.Tier != "":
+		params = append(params, "fwd=stale") // stored response needed validation
+	default:
+		params = append(params, "fwd=uri-miss")
+	}
+	if e.Fetches > 0 && e.OriginStatus > 0 {
+		params = append(params, "fwd-status="+strconv.Itoa(e.OriginStatus))
+	}
+
+	stored := fromCache
+	if e.Fetches > 0 {
+		stored = false
+		switch reason := unstorable(req, h); {
+		case e.OriginErr != nil || fromCache && e.OriginStatus != http.StatusNotModified:
+			params = append(params, "detail=origin-error") // nothing new to store
+		case reason != "":
+			params = append(params, "detail="+reason)
+		default:
+			params = append(params, "stored")
+			stored = true
+		}
+	}
+
+	if stored || fromCache {
+		if f, ok := freshness.FromHeader(h); ok {
+			params = append(params, "ttl="+strconv.Itoa(int(time.Until(f.Expires())/time.Second)))
+		}
+	}
+
+	return strings.Join(params, "; ")
+}
+
+// unstorable returns why httpcache won't store a response,
+// or an empty string if it will once fully sent.
+func unstorable(req *http.Request, h http.Header) string {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "method"
+	}
+	if req.Header.Get("Range") != "" {
+		return "range"
+	}
+	for _, header := range []http.Header{req.Header, h} {
+		for _, v := range header["Cache-Control"] {
+			for _, directive := range strings.Split(v, ",") {
+				if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+					return "no-store"
+				}
+			}
+		}
+	}
+	for _, v := range h["Vary"] {
+		for _, field := range strings.Split(v, ",") {
+			if strings.TrimSpace(field) == "*" {
+				return "vary-star"
+			}
+		}
+	}
+	return ""
+}
+
+// item returns s as a structured field token if it is one,
+// or as a string.
+func item(s string) string {
+	isToken := s != ""
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '*':
+		case i == 0:
+			isToken = false
+		case c >= '0' && c <= '9', strings.ContainsRune("!#$%&'+-.^_`|~:/", c):
+		default:
+			isToken = false
+		}
+	}
+	if isToken {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
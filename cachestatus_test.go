@@ -0,0 +1,126 @@
+package getcached
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestCacheStatus(t *testing.T) {
+	status := http.StatusOK
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		rw.Header().Set("Etag", `"v1"`)
+		switch req.URL.Path {
+		case "/fresh":
+			rw.Header().Set("Cache-Control", "max-age=3600")
+			rw.Header().Add(CacheStatusHeader, "upstream; fwd=uri-miss")
+		case "/stale":
+			rw.Header().Set("Cache-Control", "max-age=0, stale-if-error=3600")
+			if req.Header.Get("If-None-Match") == `"v1"` && status == http.StatusOK {
+				rw.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/private":
+			rw.Header().Set("Cache-Control", "no-store")
+		}
+		rw.WriteHeader(status)
+		rw.Write([]byte("content"))
+	}))
+	defer origin.Close()
+
+	memory, disk := httpcache.NewMemoryCache(), httpcache.NewMemoryCache()
+	p := New(WithCache(Tiers{{"memory", memory}, {"disk", disk}}), WithCacheStatus("node1"))
+
+	tests := []struct {
+		path   string
+		status int
+		setup  func()
+		want   string
+	}{
+		{"/fresh", http.StatusOK, nil, `^upstream; fwd=uri-miss, node1; fwd=uri-miss; fwd-status=200; stored; ttl=3\d{3}$`},
+		{"/fresh", http.StatusOK, nil, `^upstream; fwd=uri-miss, node1/disk; hit; ttl=3\d{3}$`},
+		{"/fresh", http.StatusOK, nil, `^upstream; fwd=uri-miss, node1/memory; hit; ttl=3\d{3}$`},
+		{"/fresh", http.StatusOK, func() { memory.Delete(origin.URL + "/fresh") }, `, node1/disk; hit; ttl=3\d{3}$`},
+		{"/stale", http.StatusOK, nil, `^node1; fwd=uri-miss; fwd-status=200; stored; ttl=0$`},
+		{"/stale", http.StatusOK, nil, `^node1/disk; fwd=stale; fwd-status=304; stored; ttl=-?\d$`},
+		{"/stale", http.StatusInternalServerError, nil, `^node1/disk; fwd=stale; fwd-status=500; detail=origin-error; ttl=-?\d$`},
+		{"/private", http.StatusOK, nil, `^node1; fwd=uri-miss; fwd-status=200; detail=no-store$`},
+	}
+
+	for _, test := range tests {
+		status = test.status
+		if test.setup != nil {
+			test.setup()
+		}
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest("GET", "/?q="+url.QueryEscape(origin.URL+test.path), nil))
+
+		got := joined(rr.Header()[CacheStatusHeader])
+		if !regexp.MustCompile(test.want).MatchString(got) {
+			t.Errorf("unexpected %s header for %s: got %q, want match of %s", CacheStatusHeader, test.path, got, test.want)
+		}
+	}
+}
+
+func TestCacheStatusExternal(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set(CacheStatusHeader, "upstream; hit")
+	}))
+	defer origin.Close()
+
+	_, internal, _ := net.ParseCIDR("10.0.0.0/8")
+	p := New(WithCacheStatus("node1", internal))
+
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"10.1.2.3:1234", "upstream; hit, node1; fwd=uri-miss; fwd-status=200; stored"},
+		{"192.0.2.1:1234", ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/?q="+url.QueryEscape(origin.URL), nil)
+		req.RemoteAddr = test.remote
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, req)
+
+		if got := joined(rr.Header()[CacheStatusHeader]); got != test.want {
+			t.Errorf("unexpected %s header for %s: got %q, want %q", CacheStatusHeader, test.remote, got, test.want)
+		}
+	}
+}
+
+func TestItem(t *testing.T) {
+	tests := map[string]string{
+		"node1":      "node1",
+		"node1/disk": "node1/disk",
+		"1node":      `"1node"`,
+		`my "proxy"`: `"my \"proxy\""`,
+		"":           `""`,
+	}
+
+	for s, want := range tests {
+		if got := item(s); got != want {
+			t.Errorf("unexpected item of %q: got %s, want %s", s, got, want)
+		}
+	}
+}
+
+func joined(values []string) string {
+	s := ""
+	for i, v := range values {
+		if i > 0 {
+			s += ", "
+		}
+		s += v
+	}
+	return s
+}
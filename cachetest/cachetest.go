@@ -0,0 +1,210 @@
+// Package cachetest provides a conformance suite every
+// httpcache.Cache implementation of getcached must pass.
+package cachetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+)
+
+const (
+	largeSize  = 4 << 20 // 4MB
+	goroutines = 8
+	iterations = 100
+)
+
+// Factory creates the cache under test. Caches implementing
+// io.Closer are closed at the end of each test. Tests use
+// distinct keys so successive caches may share their storage.
+type Factory func() httpcache.Cache
+
+// RunConformance runs the conformance suite against the
+// caches created by factory.
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, httpcache.Cache, string)
+	}{
+		{"GetMissing", testGetMissing},
+		{"SetGet", testSetGet},
+		{"Overwrite", testOverwrite},
+		{"EmptyValue", testEmptyValue},
+		{"LargeValue", testLargeValue},
+		{"BinaryValue", testBinaryValue},
+		{"Keys", testKeys},
+		{"Delete", testDelete},
+		{"DeleteMissing", testDeleteMissing},
+		{"ConcurrentSameKey", testConcurrentSameKey},
+		{"ConcurrentKeys", testConcurrentKeys},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			c := factory()
+			if closer, ok := c.(io.Closer); ok {
+				defer closer.Close()
+			}
+			test.fn(t, c, test.name+":")
+		})
+	}
+}
+
+func testGetMissing(t *testing.T, c httpcache.Cache, prefix string) {
+	if got, ok := c.Get(prefix + "missing"); ok {
+		t.Errorf("unexpected value for missing key: got %q", got)
+	}
+}
+
+func testSetGet(t *testing.T, c httpcache.Cache, prefix string) {
+	value := []byte("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\ncontent")
+	c.Set(prefix+"key", value)
+	expect(t, c, prefix+"key", value)
+}
+
+func testOverwrite(t *testing.T, c httpcache.Cache, prefix string) {
+	c.Set(prefix+"key", []byte("a longer first value"))
+	c.Set(prefix+"key", []byte("short"))
+	expect(t, c, prefix+"key", []byte("short"))
+
+	c.Set(prefix+"key", []byte("a longer third value"))
+	expect(t, c, prefix+"key", []byte("a longer third value"))
+}
+
+func testEmptyValue(t *testing.T, c httpcache.Cache, prefix string) {
+	c.Set(prefix+"key", []byte{})
+	expect(t, c, prefix+"key", []byte{})
+}
+
+func testLargeValue(t *testing.T, c httpcache.Cache, prefix string) {
+	value := bytes.Repeat([]byte("0123456789abcdef"), largeSize/16)
+	c.Set(prefix+"key", value)
+	expect(t, c, prefix+"key", value)
+}
+
+func testBinaryValue(t *testing.T, c httpcache.Cache, prefix string) {
+	value := []byte("HTTP/1.1 200 OK\r\n\r\n")
+	for i := 0; i < 512; i++ {
+		value = append(value, byte(i))
+	}
+	value = append(value, "\r\n\r\n\x00"...)
+	c.Set(prefix+"key", value)
+	expect(t, c, prefix+"key", value)
+}
+
+func testKeys(t *testing.T, c httpcache.Cache, prefix string) {
+	keys := []string{
+		"http://example.com/path?query=1&other=a%20b#fragment",
+		"http://example.com/path content-encoding=gzip",
+		"http://例え.jp/ünïcödé",
+		"../../escape",
+		"line\nbreak",
+		strings.Repeat("long", 1000),
+	}
+
+	for i, key := range keys {
+		c.Set(prefix+key, []byte(fmt.Sprintf("value%d", i)))
+	}
+	for i, key := range keys {
+		expect(t, c, prefix+key, []byte(fmt.Sprintf("value%d", i)))
+	}
+}
+
+func testDelete(t *testing.T, c httpcache.Cache, prefix string) {
+	c.Set(prefix+"key1", []byte("value1"))
+	c.Set(prefix+"key2", []byte("value2"))
+	c.Delete(prefix + "key1")
+
+	if got, ok := c.Get(prefix + "key1"); ok {
+		t.Errorf("unexpected value for deleted key: got %q", got)
+	}
+	expect(t, c, prefix+"key2", []byte("value2"))
+
+	c.Set(prefix+"key1", []byte("value3"))
+	expect(t, c, prefix+"key1", []byte("value3"))
+}
+
+func testDeleteMissing(t *testing.T, c httpcache.Cache, prefix string) {
+	c.Delete(prefix + "missing")
+	if got, ok := c.Get(prefix + "missing"); ok {
+		t.Errorf("unexpected value for missing key: got %q", got)
+	}
+}
+
+// testConcurrentSameKey checks that concurrent readers of a
+// key never see a value that was not written as a whole.
+func testConcurrentSameKey(t *testing.T, c httpcache.Cache, prefix string) {
+	key := prefix + "key"
+	values := map[string]bool{}
+	for i := 0; i < goroutines; i++ {
+		values[strings.Repeat(string(rune('a'+i)), 100*(i+1))] = true
+	}
+
+	stress(func(g, i int) {
+		switch i % 3 {
+		case 0:
+			c.Set(key, bytes.Repeat([]byte{byte('a' + g)}, 100*(g+1)))
+		case 1:
+			if got, ok := c.Get(key); ok && !values[string(got)] {
+				t.Errorf("unexpected value for %.64q: got %d bytes", key, len(got))
+			}
+		case 2:
+			if g == 0 {
+				c.Delete(key)
+			}
+		}
+	})
+}
+
+// testConcurrentKeys checks that concurrent writers of
+// distinct keys don't interfere.
+func testConcurrentKeys(t *testing.T, c httpcache.Cache, prefix string) {
+	stress(func(g, i int) {
+		key := fmt.Sprintf("%skey%d-%d", prefix, g, i%10)
+		value := []byte(fmt.Sprintf("value%d-%d", g, i))
+
+		c.Set(key, value)
+		if got, ok := c.Get(key); !ok || !bytes.Equal(got, value) {
+			t.Errorf("value mismatch for %.64q: got %q, want %q", key, got, value)
+		}
+		if i%4 == 0 {
+			c.Delete(key)
+		}
+	})
+}
+
+func stress(fn func(g, i int)) {
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				fn(g, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func expect(t *testing.T, c httpcache.Cache, key string, want []byte) {
+	t.Helper()
+	got, ok := c.Get(key)
+	if !ok {
+		t.Errorf("missing value for %.64q", key)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		if len(want) > 64 {
+			t.Errorf("value mismatch for %.64q: got %d bytes, want %d bytes", key, len(got), len(want))
+			return
+		}
+		t.Errorf("value mismatch for %.64q: got %q, want %q", key, got, want)
+	}
+}
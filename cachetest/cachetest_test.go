@@ -0,0 +1,11 @@
+package cachetest
+
+import (
+	"testing"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestMemoryCache(t *testing.T) {
+	RunConformance(t, func() httpcache.Cache { return httpcache.NewMemoryCache() })
+}
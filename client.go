@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/mikegleasonjr/getcached/shard"
+	"github.com/mikegleasonjr/getcached/trace"
 )
 
 var (
@@ -17,16 +18,20 @@ var (
 
 // Client is a client of a list of proxies.
 type Client struct {
-	transport http.RoundTripper
-	mu        sync.RWMutex // guards picker ops
-	picker    Picker
+	transport   http.RoundTripper
+	mu          sync.RWMutex // guards picker ops
+	picker      Picker
+	concurrency int     // max concurrent prefetch requests
+	rate        float64 // max prefetch requests per second, 0 when unlimited
+	tracer      *trace.Tracer
 }
 
 // NewClient creates a Client.
 func NewClient(options ...func(*Client)) *Client {
 	c := &Client{
-		picker:    shard.New(),
-		transport: http.DefaultTransport,
+		picker:      shard.New(),
+		transport:   http.DefaultTransport,
+		concurrency: defaultPrefetchConcurrency,
 	}
 
 	for _, option := range options {
@@ -70,7 +75,21 @@ func (c *Client) RoundTrip(req *http.Request) (*http.Response, error) {
 	cpy.URL = proxy
 	cpy.Host = proxy.Host
 
-	return c.transport.RoundTrip(cpy)
+	if c.tracer == nil {
+		trace.Inject(req.Context(), cpy.Header)
+		return c.transport.RoundTrip(cpy)
+	}
+
+	ctx, span := c.tracer.Start(req.Context(), "getcached.client", trace.Client)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("url.full", origin)
+	span.SetAttribute("server.address", proxy.Host)
+	cpy = cpy.WithContext(ctx)
+	trace.Inject(ctx, cpy.Header)
+
+	res, err := c.transport.RoundTrip(cpy)
+	finish(span, res, err)
+	return res, err
 }
 
 // WithPicker configures a Client to use
@@ -89,6 +108,31 @@ func WithClientTransport(tr http.RoundTripper) func(*Client) {
 	}
 }
 
+// WithPrefetchConcurrency configures the max number
+// of concurrent requests made by Prefetch.
+func WithPrefetchConcurrency(n int) func(*Client) {
+	return func(c *Client) {
+		c.concurrency = n
+	}
+}
+
+// WithPrefetchRate configures the max number of requests
+// per second made by Prefetch.
+func WithPrefetchRate(perSecond float64) func(*Client) {
+	return func(c *Client) {
+		c.rate = perSecond
+	}
+}
+
+// WithClientTracer configures a Client to trace the
+// requests it makes. Without a Tracer, a Client only
+// propagates the trace of the requests to the proxies.
+func WithClientTracer(t *trace.Tracer) func(*Client) {
+	return func(c *Client) {
+		c.tracer = t
+	}
+}
+
 // clones a request, credits goes to:
 // https://github.com/golang/oauth2/blob/master/transport.go#L36
 func clone(r *http.Request) *http.Request {
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mikegleasonjr/getcached"
+)
+
+var logFormats = map[string]getcached.LogFormat{
+	"json":     getcached.JSONLog,
+	"common":   getcached.CommonLog,
+	"combined": getcached.CombinedLog,
+}
+
+// configureAccessLog returns an access log writing to
+// stdout, or to a file rotated once it reaches maxsize.
+func configureAccessLog(dest, format string, sampling float64, maxsize uint64, backups int) (*getcached.AccessLog, error) {
+	var w io.Writer = os.Stdout
+	if dest != "-" {
+		f, err := openRotatingFile(dest, int64(maxsize), backups)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	return getcached.NewAccessLog(w,
+		getcached.WithLogFormat(logFormats[format]),
+		getcached.WithLogSampling(sampling),
+	), nil
+}
+
+// rotatingFile is a file renamed with a numbered suffix
+// once it reaches a max size, keeping a number of backups.
+type rotatingFile struct {
+	path    string
+	maxsize int64
+	backups int
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxsize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxsize: maxsize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxsize > 0 && r.size > 0 && r.size+int64(len(b)) > r.maxsize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	if r.backups > 0 {
+		for i := r.backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+
+	return r.open()
+}
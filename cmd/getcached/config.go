@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/units"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+const configPollInterval = 5 * time.Second
+
+// config is a YAML file setting flags by their name, as in:
+//
+//	memory-size: 256MiB
+//	enable-disk-cache: true
+//	peer:
+//	  - http://10.0.0.1:3000
+//	  - http://10.0.0.2:3000
+//
+// Flags given on the command line or in the environment
+// take precedence. The file is reloaded on SIGHUP or when
+// modified, applying the changes of the flags having a
+// handler and ignoring the others until a restart.
+type config struct {
+	path     string
+	app      *kingpin.Application
+	args     []string            // command line parsed by app
+	mu       sync.Mutex          // guards the fields below
+	values   map[string][]string // values of the flags set by the file
+	modTime  time.Time
+	handlers map[string]func(values []string) error
+}
+
+// loadConfig reads a config file and sets the flags of app
+// it holds, which must have been parsed from args.
+func loadConfig(app *kingpin.Application, path string, args []string) (*config, error) {
+	c := &config{
+		path:     path,
+		app:      app,
+		args:     args,
+		handlers: map[string]func([]string) error{},
+	}
+
+	values, modTime, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, vs := range values {
+		flag := app.GetFlag(name).Model()
+		if explicit(flag, args) {
+			continue
+		}
+		for _, v := range vs {
+			if err := flag.Value.Set(v); err != nil {
+				return nil, fmt.Errorf("%s: invalid %s: %s", path, name, err)
+			}
+		}
+	}
+
+	c.values, c.modTime = values, modTime
+	return c, nil
+}
+
+// explicit tells if a flag is set on the command line or
+// in the environment.
+func explicit(flag *kingpin.FlagModel, args []string) bool {
+	if flag.Envar != "" && os.Getenv(flag.Envar) != "" {
+		return true
+	}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+flag.Name || arg == "--no-"+flag.Name || strings.HasPrefix(arg, "--"+flag.Name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// configured tells if a flag is set on the command line, in
+// the environment or by the file of cfg, which may be nil.
+func configured(cfg *config, name string) bool {
+	if explicit(kingpin.CommandLine.GetFlag(name).Model(), os.Args[1:]) {
+		return true
+	}
+	if cfg == nil {
+		return false
+	}
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	_, ok := cfg.values[name]
+	return ok
+}
+
+// handle applies the changes of a flag on reloads with fn,
+// called with its new values, or its defaults when removed
+// from the file.
+func (c *config) handle(name string, fn func(values []string) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[name] = fn
+}
+
+// watch reloads the file on SIGHUP or when modified, as
+// seen every interval.
+func (c *config) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			fi, err := os.Stat(c.path)
+			c.mu.Lock()
+			modified := err == nil && !fi.ModTime().Equal(c.modTime)
+			c.mu.Unlock()
+			if !modified {
+				continue
+			}
+		}
+		c.reload()
+	}
+}
+
+// reload applies the changes of the file. A file which can't
+// be read is rejected as a whole.
+func (c *config) reload() {
+	values, modTime, err := c.read()
+	if err != nil {
+		stderr.Printf("config: %s, keeping the current configuration", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.modTime = modTime
+
+	names := map[string]bool{}
+	for name := range values {
+		names[name] = true
+	}
+	for name := range c.values {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		flag := c.app.GetFlag(name).Model()
+		vs, set := values[name]
+		if !set {
+			vs = flag.Default
+		}
+		if equal(vs, c.values[name]) {
+			continue
+		}
+		if explicit(flag, c.args) {
+			stderr.Printf("config: %s is set on the command line or in the environment, ignoring its change", name)
+			continue
+		}
+		handler, ok := c.handlers[name]
+		if !ok {
+			stderr.Printf("config: %s can't change while running, restart to apply it", name)
+			continue
+		}
+		if err := handler(vs); err != nil {
+			stderr.Printf("config: invalid %s: %s", name, err)
+			continue
+		}
+		if set {
+			stdout.Printf("config: %s changed to %s", name, strings.Join(vs, ", "))
+			c.values[name] = vs
+		} else {
+			stdout.Printf("config: %s reset to its default", name)
+			delete(c.values, name)
+		}
+	}
+}
+
+// read returns the values of the flags set by the file.
+func (c *config) read() (map[string][]string, time.Time, error) {
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	b, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %s", c.path, err)
+	}
+
+	values := map[string][]string{}
+	for name, v := range doc {
+		if name == "config" || name == "help" || name == "version" || c.app.GetFlag(name) == nil {
+			return nil, time.Time{}, fmt.Errorf("%s: unknown flag %s", c.path, name)
+		}
+		switch v := v.(type) {
+		case []interface{}:
+			for _, e := range v {
+				s, ok := scalar(e)
+				if !ok {
+					return nil, time.Time{}, fmt.Errorf("%s: %s must be a list of values", c.path, name)
+				}
+				values[name] = append(values[name], s)
+			}
+		default:
+			s, ok := scalar(v)
+			if !ok {
+				return nil, time.Time{}, fmt.Errorf("%s: %s must be a value or a list of values", c.path, name)
+			}
+			values[name] = []string{s}
+		}
+	}
+
+	return values, fi.ModTime(), nil
+}
+
+// size parses the single value of a size flag the way kingpin
+// does, for handlers to validate it before applying it.
+func size(values []string) (int64, error) {
+	if len(values) != 1 {
+		return 0, fmt.Errorf("expected a single size, got %d", len(values))
+	}
+	n, err := units.ParseBase2Bytes(values[0])
+	if err != nil {
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+// capacity tells if n is a valid capacity of a tier, which must
+// be positive and below math.MaxInt64.
+func capacity(n int64) error {
+	if n <= 0 || n == math.MaxInt64 {
+		return fmt.Errorf("size must be positive and below %d bytes", int64(math.MaxInt64))
+	}
+	return nil
+}
+
+func scalar(v interface{}) (string, bool) {
+	switch v.(type) {
+	case string, bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alecthomas/units"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type flags struct {
+	app   *kingpin.Application
+	name  *string
+	size  *units.Base2Bytes
+	peers *[]string
+}
+
+func application(t *testing.T, args []string) flags {
+	app := kingpin.New("test", "")
+	f := flags{
+		app:   app,
+		name:  app.Flag("name", "").Default("none").String(),
+		size:  app.Flag("size", "").Default("1KiB").Bytes(),
+		peers: app.Flag("peer", "").Strings(),
+	}
+	if _, err := app.Parse(args); err != nil {
+		t.Fatalf("unexpected error parsing %v: %s", args, err)
+	}
+	return f
+}
+
+func writeConfig(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("unexpected error writing config: %s", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		file   string
+		args   []string
+		want   string
+		size   units.Base2Bytes
+		peers  []string
+		values map[string][]string
+		err    bool
+	}{
+		{"values", "name: a\nsize: 2KiB\npeer: [a.test, b.test]\n", nil, "a", 2048, []string{"a.test", "b.test"}, map[string][]string{"name": {"a"}, "size": {"2KiB"}, "peer": {"a.test", "b.test"}}, false},
+		{"scalars", "name: 42\n", nil, "42", 1024, nil, map[string][]string{"name": {"42"}}, false},
+		{"command line", "name: a\nsize: 2KiB\n", []string{"--name=b"}, "b", 2048, nil, map[string][]string{"name": {"a"}, "size": {"2KiB"}}, false},
+		{"empty", "", nil, "none", 1024, nil, map[string][]string{}, false},
+		{"unknown", "other: a\n", nil, "", 0, nil, nil, true},
+		{"reserved", "help: true\n", nil, "", 0, nil, nil, true},
+		{"invalid", "size: lots\n", nil, "", 0, nil, nil, true},
+		{"nested", "name: {a: b}\n", nil, "", 0, nil, nil, true},
+		{"list of lists", "peer: [[x]]\n", nil, "", 0, nil, nil, true},
+		{"syntax", "name: [a\n", nil, "", 0, nil, nil, true},
+	}
+
+	for _, test := range tests {
+		f := application(t, test.args)
+		c, err := loadConfig(f.app, writeConfig(t, dir, test.file), test.args)
+		if got := err != nil; got != test.err {
+			t.Errorf("unexpected error of %s: got %v, want error %t", test.name, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if *f.name != test.want || *f.size != test.size || !reflect.DeepEqual(*f.peers, test.peers) {
+			t.Errorf("unexpected flags of %s: got %s/%d/%v, want %s/%d/%v", test.name, *f.name, *f.size, *f.peers, test.want, test.size, test.peers)
+		}
+		if !reflect.DeepEqual(c.values, test.values) {
+			t.Errorf("unexpected values of %s: got %v, want %v", test.name, c.values, test.values)
+		}
+	}
+
+	if _, err := loadConfig(application(t, nil).app, filepath.Join(dir, "missing.yml"), nil); err == nil {
+		t.Errorf("expected an error loading a missing config")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		before  string
+		after   string
+		args    []string
+		want    string
+		size    units.Base2Bytes
+		peers   []string
+		values  map[string][]string
+		applied int
+	}{
+		{"changed", "size: 2KiB\n", "size: 4KiB\n", nil, "none", 4096, nil, map[string][]string{"size": {"4KiB"}}, 1},
+		{"unchanged", "size: 2KiB\n", "size: 2KiB\n", nil, "none", 2048, nil, map[string][]string{"size": {"2KiB"}}, 0},
+		{"added", "", "size: 4KiB\n", nil, "none", 4096, nil, map[string][]string{"size": {"4KiB"}}, 1},
+		{"removed", "size: 2KiB\n", "", nil, "none", 1024, nil, map[string][]string{}, 1},
+		{"invalid", "size: 2KiB\n", "size: lots\n", nil, "none", 2048, nil, map[string][]string{"size": {"2KiB"}}, 0},
+		{"several", "size: 2KiB\n", "size: [4KiB, 8KiB]\n", nil, "none", 2048, nil, map[string][]string{"size": {"2KiB"}}, 0},
+		{"no handler", "name: a\n", "name: b\n", nil, "a", 1024, nil, map[string][]string{"name": {"a"}}, 0},
+		{"command line", "size: 2KiB\n", "size: 4KiB\n", []string{"--size", "8KiB"}, "none", 8192, nil, map[string][]string{"size": {"2KiB"}}, 0},
+		{"unreadable", "size: 2KiB\n", "size: [4KiB\n", nil, "none", 2048, nil, map[string][]string{"size": {"2KiB"}}, 0},
+		{"unknown", "size: 2KiB\n", "size: 4KiB\nother: a\n", nil, "none", 2048, nil, map[string][]string{"size": {"2KiB"}}, 0},
+		{"list", "peer: [a.test]\n", "peer: [a.test, b.test]\n", nil, "none", 1024, []string{"a.test", "b.test"}, map[string][]string{"peer": {"a.test", "b.test"}}, 1},
+		{"partial", "size: 2KiB\npeer: [a.test]\n", "size: lots\npeer: [b.test]\n", nil, "none", 2048, []string{"b.test"}, map[string][]string{"size": {"2KiB"}, "peer": {"b.test"}}, 1},
+	}
+
+	for _, test := range tests {
+		f := application(t, test.args)
+		path := writeConfig(t, dir, test.before)
+		c, err := loadConfig(f.app, path, test.args)
+		if err != nil {
+			t.Fatalf("unexpected error loading %s: %s", test.name, err)
+		}
+
+		applied := 0
+		c.handle("size", func(values []string) error {
+			if _, err := size(values); err != nil {
+				return err
+			}
+			applied++
+			return f.app.GetFlag("size").Model().Value.Set(values[0])
+		})
+		c.handle("peer", func(values []string) error {
+			applied++
+			*f.peers = append([]string(nil), values...)
+			return nil
+		})
+
+		writeConfig(t, dir, test.after)
+		c.reload()
+
+		if *f.name != test.want || *f.size != test.size || !reflect.DeepEqual(*f.peers, test.peers) {
+			t.Errorf("unexpected flags of %s: got %s/%d/%v, want %s/%d/%v", test.name, *f.name, *f.size, *f.peers, test.want, test.size, test.peers)
+		}
+		if !reflect.DeepEqual(c.values, test.values) {
+			t.Errorf("unexpected values of %s: got %v, want %v", test.name, c.values, test.values)
+		}
+		if applied != test.applied {
+			t.Errorf("unexpected changes applied by %s: got %d, want %d", test.name, applied, test.applied)
+		}
+	}
+}
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   int64
+		err    bool
+	}{
+		{[]string{"512B"}, 512, false},
+		{[]string{"10MiB"}, 10 << 20, false},
+		{[]string{"1GB"}, 1 << 30, false},
+		{[]string{"lots"}, 0, true},
+		{[]string{""}, 0, true},
+		{nil, 0, true},
+		{[]string{"1KiB", "2KiB"}, 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := size(test.values)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("unexpected size of %v: got %d (%v), want %d (error %t)", test.values, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	tests := []struct {
+		size int64
+		err  bool
+	}{
+		{1, false},
+		{math.MaxInt64 - 1, false},
+		{0, true},
+		{-1, true},
+		{math.MaxInt64, true},
+	}
+
+	for _, test := range tests {
+		if err := capacity(test.size); (err != nil) != test.err {
+			t.Errorf("unexpected error of capacity %d: got %v, want error %t", test.size, err, test.err)
+		}
+	}
+}
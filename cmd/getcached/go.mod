@@ -3,9 +3,10 @@ module github.com/mikegleasonjr/getcached/cmd/getcached
 go 1.13
 
 require (
-	github.com/die-net/lrucache v0.0.0-20190707192454-883874fe3947
+	github.com/alecthomas/units v0.0.0-20151022065526-2efee857e7cf
 	github.com/gregjones/httpcache v0.0.0-20190611155906-901d90724c79
 	github.com/mikegleasonjr/getcached v0.0.5
 	github.com/prometheus/client_golang v1.1.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
+	gopkg.in/yaml.v2 v2.2.2
 )
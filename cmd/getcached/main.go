@@ -2,68 +2,346 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/die-net/lrucache/twotier"
 	"github.com/gregjones/httpcache"
 	"github.com/mikegleasonjr/getcached"
+	"github.com/mikegleasonjr/getcached/boltdb"
+	"github.com/mikegleasonjr/getcached/compressed"
 	"github.com/mikegleasonjr/getcached/disk"
+	"github.com/mikegleasonjr/getcached/logstore"
 	"github.com/mikegleasonjr/getcached/lru"
+	"github.com/mikegleasonjr/getcached/memcache"
+	"github.com/mikegleasonjr/getcached/redis"
+	"github.com/mikegleasonjr/getcached/s3"
+	"github.com/mikegleasonjr/getcached/shard"
+	"github.com/mikegleasonjr/getcached/snapshot"
+	"github.com/mikegleasonjr/getcached/trace"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+// shutdownTimeout bounds the time left to the requests in
+// flight to complete on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 var (
 	version     = "v0.0.0-dev"
 	stderr      = log.New(os.Stderr, "[getcached] ", log.LstdFlags|log.Lshortfile)
 	stdout      = log.New(os.Stdout, "[getcached] ", log.LstdFlags)
+	configpath  = kingpin.Flag("config", "YAML file setting flags by name, reloaded on SIGHUP or when modified, overridden by the command line and environment (env CP_CONFIG)").Default("").Envar("CP_CONFIG").String()
 	listen      = kingpin.Flag("listen", "Listen address (env CP_LISTEN)").Default(":3000").Envar("CP_LISTEN").TCP()
 	memsize     = kingpin.Flag("memory-size", "Memory cache size (env CP_MEMORY_SIZE)").Default("25MiB").Envar("CP_MEMORY_SIZE").Bytes()
 	diskenabled = kingpin.Flag("enable-disk-cache", "Enable tiered disk cache (env CP_ENABLE_DISK_CACHE)").Default("false").Envar("CP_ENABLE_DISK_CACHE").Default("false").Bool()
 	diskdir     = kingpin.Flag("cache-dir", "Cache directory if disk cache enabled (env CP_DISK_CACHE_DIR)").Default(os.TempDir()).PlaceHolder("$TMPDIR").Envar("CP_DISK_CACHE_DIR").ExistingDir()
 	disksize    = kingpin.Flag("cache-dir-size", "Disk cache size if disk cache enabled (env CP_DISK_CACHE_SIZE)").Default("100MiB").Envar("CP_DISK_CACHE_SIZE").Bytes()
+	diskbackend = kingpin.Flag("cache-dir-backend", "Disk cache storage, one file per entry, append-only segments or a single bolt database (env CP_DISK_CACHE_BACKEND)").Default("files").Envar("CP_DISK_CACHE_BACKEND").Enum("files", "log", "bolt")
+	diskdepth   = kingpin.Flag("cache-dir-depth", "Levels of subdirectories disk cache entries are spread into (env CP_DISK_CACHE_DEPTH)").Default("2").Envar("CP_DISK_CACHE_DEPTH").Int()
+	diskdedup   = kingpin.Flag("cache-dir-dedup", "Store identical response bodies once on disk (env CP_DISK_CACHE_DEDUP)").Default("false").Envar("CP_DISK_CACHE_DEDUP").Bool()
+	diskscrub   = kingpin.Flag("cache-dir-scrub", "Pause between disk cache entries verified in the background, 0 to disable (env CP_DISK_CACHE_SCRUB)").Default("0").Envar("CP_DISK_CACHE_SCRUB").Duration()
+	remotecache = kingpin.Flag("remote-cache", "Shared cache tier, as redis://host:port or memcached://host:port (env CP_REMOTE_CACHE)").Default("").Envar("CP_REMOTE_CACHE").URL()
+	remotewait  = kingpin.Flag("remote-cache-timeout", "Time getting a connection or waiting for the remote cache to make progress, after which calls are treated as misses (env CP_REMOTE_CACHE_TIMEOUT)").Default("100ms").Envar("CP_REMOTE_CACHE_TIMEOUT").Duration()
+	s3bucket    = kingpin.Flag("s3-bucket", "Bucket of an S3 compatible cold cache tier, credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (env CP_S3_BUCKET)").Default("").Envar("CP_S3_BUCKET").String()
+	s3endpoint  = kingpin.Flag("s3-endpoint", "URL of the S3 compatible service (env CP_S3_ENDPOINT)").Default("https://s3.amazonaws.com").Envar("CP_S3_ENDPOINT").String()
+	s3region    = kingpin.Flag("s3-region", "Region of the S3 bucket (env CP_S3_REGION)").Default("us-east-1").Envar("CP_S3_REGION").String()
+	s3prefix    = kingpin.Flag("s3-prefix", "Prefix of the S3 cache objects (env CP_S3_PREFIX)").Default("getcached/").Envar("CP_S3_PREFIX").String()
 	maxbodysize = kingpin.Flag("max-body-size", "Max response body size allowed to be downloaded (env CP_MAX_BODY_SIZE)").Default("10MiB").Envar("CP_MAX_BODY_SIZE").Bytes()
+	sweepevery  = kingpin.Flag("sweep-interval", "Interval between expired entries sweeps, 0 to disable (env CP_SWEEP_INTERVAL)").Default("0").Envar("CP_SWEEP_INTERVAL").Duration()
+	sweepgrace  = kingpin.Flag("sweep-grace", "Time expired entries are kept past their stale window (env CP_SWEEP_GRACE)").Default("1h").Envar("CP_SWEEP_GRACE").Duration()
+	compress    = kingpin.Flag("compress", "Compress text, font and wasm responses in the caches (env CP_COMPRESS)").Default("false").Envar("CP_COMPRESS").Bool()
+	maxvariants = kingpin.Flag("max-variants", "Max variants cached per resource when responses vary (env CP_MAX_VARIANTS)").Default("8").Envar("CP_MAX_VARIANTS").Int()
+	admin       = kingpin.Flag("enable-admin", "Serve tier snapshots under /admin/snapshot/<tier>, cache entries under /admin/entry?url=<url>, keys under /admin/keys/<tier> and resizes tiers with POST /admin/resize/<tier>?size=<bytes>, on the admin listen address (env CP_ENABLE_ADMIN)").Default("false").Envar("CP_ENABLE_ADMIN").Bool()
+	adminlisten = kingpin.Flag("admin-listen", "Listen address of the admin API, reachable by anyone who can connect to it (env CP_ADMIN_LISTEN)").Default("127.0.0.1:3001").Envar("CP_ADMIN_LISTEN").TCP()
+	peers       = kingpin.Flag("peer", "URL of a proxy of the fleet, repeatable, for the admin API to tell which one owns a URL (env CP_PEERS)").Envar("CP_PEERS").Strings()
+	maxorigins  = kingpin.Flag("metrics-max-origins", "Max origin hosts with their own request metrics, others being reported as \"other\" (env CP_METRICS_MAX_ORIGINS)").Default("100").Envar("CP_METRICS_MAX_ORIGINS").Int()
+	accesslog   = kingpin.Flag("access-log", "Access log file, - for stdout (env CP_ACCESS_LOG)").Default("").Envar("CP_ACCESS_LOG").String()
+	logformat   = kingpin.Flag("access-log-format", "Access log format (env CP_ACCESS_LOG_FORMAT)").Default("json").Envar("CP_ACCESS_LOG_FORMAT").Enum("json", "common", "combined")
+	logsampling = kingpin.Flag("access-log-sampling", "Fraction of the requests logged (env CP_ACCESS_LOG_SAMPLING)").Default("1").Envar("CP_ACCESS_LOG_SAMPLING").Float64()
+	logmaxsize  = kingpin.Flag("access-log-max-size", "Size at which the access log file is rotated, 0 to disable (env CP_ACCESS_LOG_MAX_SIZE)").Default("100MiB").Envar("CP_ACCESS_LOG_MAX_SIZE").Bytes()
+	logbackups  = kingpin.Flag("access-log-backups", "Rotated access log files kept (env CP_ACCESS_LOG_BACKUPS)").Default("5").Envar("CP_ACCESS_LOG_BACKUPS").Int()
+	otlpurl     = kingpin.Flag("otlp-endpoint", "Traces endpoint of an OTLP/HTTP collector, as http://localhost:4318/v1/traces, tracing disabled if empty (env CP_OTLP_ENDPOINT)").Default("").Envar("CP_OTLP_ENDPOINT").String()
+	tracerate   = kingpin.Flag("trace-sampling", "Fraction of the requests traced, unless continuing a trace (env CP_TRACE_SAMPLING)").Default("1").Envar("CP_TRACE_SAMPLING").Float64()
+	cachestatus = kingpin.Flag("cache-status", "Describe how requests were handled in the Cache-Status response header (env CP_CACHE_STATUS)").Default("false").Envar("CP_CACHE_STATUS").Bool()
+	nodename    = kingpin.Flag("node-name", "Name of this proxy in the Cache-Status header (env CP_NODE_NAME)").Default(hostname()).PlaceHolder("$HOSTNAME").Envar("CP_NODE_NAME").String()
+	internal    = kingpin.Flag("cache-status-internal", "Network of the clients seeing the Cache-Status header, as 10.0.0.0/8, repeatable, all if none (env CP_CACHE_STATUS_INTERNAL)").Envar("CP_CACHE_STATUS_INTERNAL").Strings()
+	negotiate   = kingpin.Flag("negotiate-encoding", "Brotli or gzip encode text responses for clients accepting it (env CP_NEGOTIATE_ENCODING)").Default("false").Envar("CP_NEGOTIATE_ENCODING").Bool()
+	servecmd    = kingpin.Command("serve", "Serve the caching proxy.").Default()
+	warmcmd     = kingpin.Command("warm", "Prefetch URLs through a fleet of proxies.")
+	warmproxies = warmcmd.Flag("proxy", "URL of a proxy of the fleet, repeatable").Required().Strings()
+	warmconc    = warmcmd.Flag("concurrency", "Max concurrent requests").Default("4").Int()
+	warmrate    = warmcmd.Flag("rate", "Max requests per second, 0 for unlimited").Default("0").Float64()
+	warmsource  = warmcmd.Arg("source", "File or URL listing URLs one per line or as a sitemap, - for stdin").Default("-").String()
+	exportcmd   = kingpin.Command("export", "Export the disk cache tier to an archive, while no proxy uses it.")
+	exportpath  = exportcmd.Arg("archive", "Archive written, - for stdout").Default("-").String()
+	importcmd   = kingpin.Command("import", "Import an archive into the disk cache tier, while no proxy uses it.")
+	importpath  = importcmd.Arg("archive", "Archive read, - for stdin").Default("-").String()
 )
 
 func main() {
 	kingpin.Version(version)
-	kingpin.Parse()
+	command := kingpin.Parse()
+
+	var cfg *config
+	if *configpath != "" {
+		var err error
+		if cfg, err = loadConfig(kingpin.CommandLine, *configpath, os.Args[1:]); err != nil {
+			kingpin.Fatalf("error loading config: %s", err)
+		}
+	}
+
+	if *diskbackend != "files" {
+		for _, name := range []string{"cache-dir-depth", "cache-dir-dedup", "cache-dir-scrub"} {
+			if configured(cfg, name) {
+				kingpin.Fatalf("%s is not supported by the %s backend", name, *diskbackend)
+			}
+		}
+	}
 
-	memmon, diskmon, cache := configureCaches(uint64(*memsize), *diskenabled, *diskdir, uint64(*disksize))
-	proxy := getcached.New(
+	for name, n := range map[string]int64{"memory-size": int64(*memsize), "cache-dir-size": int64(*disksize)} {
+		if err := capacity(n); err != nil {
+			kingpin.Fatalf("invalid %s: %s", name, err)
+		}
+	}
+
+	ok := true
+	switch command {
+	case warmcmd.FullCommand():
+		ok = warm(*warmproxies, *warmconc, *warmrate, *warmsource)
+	case exportcmd.FullCommand():
+		ok = exportDisk(*exportpath)
+	case importcmd.FullCommand():
+		ok = importDisk(*importpath)
+	default:
+		serve(cfg)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func serve(cfg *config) {
+	var diskstore httpcache.Cache
+	if *diskenabled {
+		diskstore = configureDiskStore(*diskbackend, *diskdir, *diskdepth, *diskdedup, *diskscrub)
+	}
+
+	tiers := configureCaches(uint64(*memsize), diskstore, uint64(*disksize), *sweepevery, *sweepgrace, *compress)
+	if *remotecache != nil && (*remotecache).Host != "" {
+		tiers = append(tiers, tier{"remote", getcached.NewMonitor(configureStorage(configureRemoteStore(*remotecache, *remotewait), *compress))})
+	}
+	if *s3bucket != "" {
+		tiers = append(tiers, tier{"s3", getcached.NewMonitor(configureStorage(configureS3Store(*s3endpoint, *s3region, *s3bucket, *s3prefix), *compress))})
+	}
+
+	cache := chain(tiers)
+	bodylimit := int64(*maxbodysize)
+	options := []func(*getcached.Proxy){
 		getcached.WithCache(cache),
 		getcached.WithBufferPool(getcached.DefaultBufferPool),
 		getcached.WithErrorLogger(stderr),
-		getcached.WithProxyTransport(BodySizeCheckerTransport(int64(*maxbodysize), DefaultTransport())),
-	)
-	mux := getMux(proxy)
-	registerPrometheusMetrics(memmon, diskmon)
+		getcached.WithProxyTransport(BodySizeCheckerTransport(&bodylimit, DefaultTransport())),
+		getcached.WithMaxVariants(*maxvariants),
+	}
+	if *negotiate {
+		options = append(options,
+			getcached.WithContentEncoding("br", compressed.Brotli),
+			getcached.WithContentEncoding("gzip", compressed.Gzip),
+		)
+	}
+	if *accesslog != "" {
+		l, err := configureAccessLog(*accesslog, *logformat, *logsampling, uint64(*logmaxsize), *logbackups)
+		if err != nil {
+			kingpin.Fatalf("error opening access log: %s", err)
+		}
+		options = append(options, getcached.WithAccessLog(l))
+	}
+	if *otlpurl != "" {
+		exporter := trace.NewOTLPExporter(*otlpurl)
+		defer exporter.Close()
+		options = append(options, getcached.WithTracer(trace.New(trace.WithExporter(exporter), trace.WithSampling(*tracerate))))
+	}
+	if *cachestatus {
+		options = append(options, getcached.WithCacheStatus(*nodename, parseNetworks(*internal)...))
+	}
+	proxy := getcached.New(options...)
+	requests := getcached.NewRequestMonitor(proxy, getcached.WithMaxHosts(*maxorigins))
+	ring := &picker{p: shard.New()}
+	ring.Set(*peers...)
+	servers := []*http.Server{newServer((*listen).String(), getMux(requests))}
+	if *admin {
+		srv := newServer((*adminlisten).String(), getAdminMux(cache, ring))
+		// snapshots stream whole tiers
+		srv.ReadTimeout, srv.WriteTimeout = 0, 0
+		servers = append(servers, srv)
+	}
+	registerPrometheusMetrics(tiers, requests)
+
+	if cfg != nil {
+		cfg.handle("peer", func(values []string) error {
+			ring.Set(values...)
+			return nil
+		})
+		cfg.handle("memory-size", resizer(cache, "memory", "memory-size"))
+		if *diskenabled {
+			cfg.handle("cache-dir-size", resizer(cache, "disk", "cache-dir-size"))
+		}
+		cfg.handle("max-body-size", func(values []string) error {
+			n, err := size(values)
+			if err != nil {
+				return err
+			}
+			atomic.StoreInt64(&bodylimit, n)
+			return kingpin.CommandLine.GetFlag("max-body-size").Model().Value.Set(values[0])
+		})
+		go cfg.watch(configPollInterval)
+	}
 
 	stdout.Printf("%s listening on %s", version, (*listen).String())
-	stderr.Println(gracefulServe((*listen).String(), mux))
+	if *admin {
+		stdout.Printf("admin API listening on %s", (*adminlisten).String())
+	}
+	stderr.Println(gracefulServe(servers...))
+}
+
+func configureDiskStore(backend, dir string, depth int, dedup bool, scrub time.Duration) httpcache.Cache {
+	switch backend {
+	case "log":
+		return logstore.New(logstore.WithDir(dir))
+	case "bolt":
+		return boltdb.New(boltdb.WithPath(path.Join(dir, "getcached.db")))
+	}
+
+	options := []func(*disk.Cache){disk.WithDir(dir), disk.WithDepth(depth)}
+	if dedup {
+		options = append(options, disk.WithDedup())
+	}
+	if scrub > 0 {
+		options = append(options, disk.WithScrubber(scrub))
+	}
+
+	return disk.New(options...)
+}
+
+func configureRemoteStore(u *url.URL, timeout time.Duration) httpcache.Cache {
+	switch u.Scheme {
+	case "redis":
+		return redis.New(redis.WithAddr(u.Host), redis.WithTimeout(timeout), redis.WithIOTimeout(timeout))
+	case "memcached":
+		return memcache.New(memcache.WithAddr(u.Host), memcache.WithTimeout(timeout), memcache.WithIOTimeout(timeout))
+	}
+
+	kingpin.Fatalf("unsupported remote cache %q", u.Scheme)
+	return nil
+}
+
+func configureS3Store(endpoint, region, bucket, prefix string) httpcache.Cache {
+	return s3.New(
+		s3.WithEndpoint(endpoint),
+		s3.WithRegion(region),
+		s3.WithBucket(bucket),
+		s3.WithPrefix(prefix),
+		s3.WithCredentials(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")),
+	)
+}
+
+func configureStorage(c httpcache.Cache, compress bool) httpcache.Cache {
+	if compress {
+		return compressed.New(compressed.WithCache(c))
+	}
+	return c
 }
 
-func configureCaches(memsize uint64, diskenabled bool, diskdir string, disksize uint64) (memmon *getcached.Monitor, diskmon *getcached.Monitor, cache httpcache.Cache) {
-	memcache := lru.New(lru.WithCache(httpcache.NewMemoryCache()), lru.WithSize(memsize))
-	memmon = getcached.NewMonitor(memcache)
-	cache = memmon
+// tier is a cache level, reported under its location.
+type tier struct {
+	loc     string
+	monitor *getcached.Monitor
+}
+
+func configureCaches(memsize uint64, diskstore httpcache.Cache, disksize uint64, sweepevery, sweepgrace time.Duration, compress bool) []tier {
+	janitor := lru.WithJanitor(sweepevery, sweepgrace)
+
+	memlru := lru.New(lru.WithCache(configureStorage(httpcache.NewMemoryCache(), compress)), lru.WithSize(memsize), janitor)
+	tiers := []tier{{"memory", getcached.NewMonitor(memlru)}}
 
-	if diskenabled {
-		diskcache := lru.New(lru.WithCache(disk.New(disk.WithDir(diskdir))), lru.WithSize(disksize))
-		diskmon = getcached.NewMonitor(diskcache)
-		cache = twotier.New(memmon, diskmon)
+	if diskstore != nil {
+		disklru := lru.New(lru.WithCache(configureStorage(diskstore, compress)), lru.WithSize(disksize), janitor)
+		tiers = append(tiers, tier{"disk", getcached.NewMonitor(disklru)})
 	}
 
-	return
+	return tiers
+}
+
+// chain puts tiers in front of each other, the first one
+// being the fastest.
+func chain(tiers []tier) getcached.Tiers {
+	cache := make(getcached.Tiers, len(tiers))
+	for i, t := range tiers {
+		cache[i] = getcached.Tier{Name: t.loc, Cache: t.monitor}
+	}
+	return cache
+}
+
+// resizer returns a config handler resizing a tier and setting
+// its size flag accordingly.
+func resizer(tiers getcached.Tiers, name, flag string) func(values []string) error {
+	return func(values []string) error {
+		n, err := size(values)
+		if err != nil {
+			return err
+		}
+		if err := capacity(n); err != nil {
+			return err
+		}
+		for _, t := range tiers {
+			if t.Name != name {
+				continue
+			}
+			r, ok := getcached.Find(t.Cache, func(c httpcache.Cache) bool {
+				_, ok := c.(getcached.Resizer)
+				return ok
+			}).(getcached.Resizer)
+			if ok {
+				r.Resize(uint64(n))
+				return kingpin.CommandLine.GetFlag(flag).Model().Value.Set(values[0])
+			}
+		}
+		return fmt.Errorf("tier %s can't be resized", name)
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "getcached"
+	}
+	return name
+}
+
+func parseNetworks(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			kingpin.Fatalf("invalid network %q: %s", cidr, err)
+		}
+		nets[i] = n
+	}
+	return nets
 }
 
-func getMux(proxy *getcached.Proxy) *http.ServeMux {
+func getMux(proxy http.Handler) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.Handle("/metrics", promhttp.Handler())
@@ -72,40 +350,86 @@ func getMux(proxy *getcached.Proxy) *http.ServeMux {
 	return mux
 }
 
-func registerPrometheusMetrics(mem, disk *getcached.Monitor) {
-	metrics := newMetrics()
-	metrics.addCollector("memory", mem)
-	if disk != nil {
-		metrics.addCollector("disk", disk)
+func getAdminMux(tiers getcached.Tiers, ring getcached.Picker) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	for _, t := range tiers {
+		mux.Handle("/admin/snapshot/"+t.Name, snapshot.Handler(t.Cache, snapshot.WithErrorLogger(stderr)))
+	}
+	mux.Handle("/admin/", http.StripPrefix("/admin", getcached.NewAdmin(tiers, getcached.WithAdminPicker(ring))))
+
+	return mux
+}
+
+func registerPrometheusMetrics(tiers []tier, requests *getcached.RequestMonitor) {
+	metrics := newMetrics(requests)
+	for _, t := range tiers {
+		metrics.addCollector(t.loc, t.monitor)
 	}
 	prometheus.MustRegister(metrics)
 }
 
-func gracefulServe(addr string, handler http.Handler) error {
-	sigchan := make(chan os.Signal)
-	res := make(chan error, 1)
-	srv := &http.Server{
-		Addr:         addr,
-		Handler:      handler,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+// picker is a getcached.Picker safe for concurrent use,
+// so that peers can be updated while serving.
+type picker struct {
+	mu sync.RWMutex
+	p  getcached.Picker
+}
+
+func (p *picker) Pick(origin string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.p.Pick(origin)
+}
+
+func (p *picker) Set(proxies ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.Set(proxies...)
+}
+
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       120 * time.Second,
 	}
+}
+
+// gracefulServe serves until a server fails or a signal is
+// received, then shuts the servers down.
+func gracefulServe(servers ...*http.Server) error {
+	sigchan := make(chan os.Signal, 1)
+	res := make(chan error, len(servers))
 
 	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		res <- srv.ListenAndServe()
-	}()
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			res <- srv.ListenAndServe()
+		}(srv)
+	}
 
+	var err error
+	pending := len(servers)
 	select {
 	case <-sigchan:
-	case err := <-res:
-		return err
+	case err = <-res:
+		pending--
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), srv.WriteTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
-	srv.Shutdown(ctx)
-	return <-res
+	for _, srv := range servers {
+		srv.Shutdown(ctx)
+	}
+	for ; pending > 0; pending-- {
+		if e := <-res; err == nil {
+			err = e
+		}
+	}
+	return err
 }
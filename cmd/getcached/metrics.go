@@ -1,6 +1,9 @@
 package main
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/mikegleasonjr/getcached"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -9,17 +12,20 @@ const (
 	ns       = "getcached"
 	subs     = "cache"
 	locLabel = "loc"
+	opLabel  = "op"
 )
 
 var m *metrics
 
 type metrics struct {
 	collectors []*collector
+	requests   *requestCollector
 	up         *prometheus.Desc
 }
 
-func newMetrics() *metrics {
+func newMetrics(requests *getcached.RequestMonitor) *metrics {
 	return &metrics{
+		requests: newRequestCollector(requests),
 		up: prometheus.NewDesc(
 			prometheus.BuildFQName(ns, "", "up"),
 			"Is getcached up?",
@@ -34,6 +40,7 @@ func (m *metrics) addCollector(loc string, monitor *getcached.Monitor) {
 
 func (m *metrics) Describe(ch chan<- *prometheus.Desc) {
 	ch <- m.up
+	m.requests.Describe(ch)
 	for _, c := range m.collectors {
 		c.Describe(ch)
 	}
@@ -41,6 +48,7 @@ func (m *metrics) Describe(ch chan<- *prometheus.Desc) {
 
 func (m *metrics) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(m.up, prometheus.GaugeValue, 1)
+	m.requests.Collect(ch)
 	for _, c := range m.collectors {
 		c.Collect(ch)
 	}
@@ -55,6 +63,16 @@ type collector struct {
 	sets      *prometheus.Desc
 	setsBytes *prometheus.Desc
 	deletes   *prometheus.Desc
+	reclaimed *prometheus.Desc
+	rawBytes  *prometheus.Desc
+	stored    *prometheus.Desc
+	corrupted *prometheus.Desc
+	used      *prometheus.Desc
+	capacity  *prometheus.Desc
+	items     *prometheus.Desc
+	evicted   *prometheus.Desc
+	latency   *prometheus.Desc
+	sizes     *prometheus.Desc
 }
 
 func newCollector(loc string, monitor *getcached.Monitor) *collector {
@@ -97,6 +115,56 @@ func newCollector(loc string, monitor *getcached.Monitor) *collector {
 			"Total number of deletion attemps from the cache.",
 			nil, constLabels,
 		),
+		reclaimed: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "reclaimed_bytes"),
+			"Total bytes of expired items removed by sweeps.",
+			nil, constLabels,
+		),
+		rawBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "compression_raw_bytes"),
+			"Total bytes given to compression.",
+			nil, constLabels,
+		),
+		stored: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "compression_stored_bytes"),
+			"Total bytes stored after compression.",
+			nil, constLabels,
+		),
+		corrupted: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "corrupted_total"),
+			"Total number of corrupted items quarantined.",
+			nil, constLabels,
+		),
+		used: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "used_bytes"),
+			"Bytes currently stored in the cache.",
+			nil, constLabels,
+		),
+		capacity: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "capacity_bytes"),
+			"Max bytes stored in the cache.",
+			nil, constLabels,
+		),
+		items: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "items"),
+			"Number of items currently stored in the cache.",
+			nil, constLabels,
+		),
+		evicted: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "evictions_total"),
+			"Total number of items removed from the cache by reason.",
+			[]string{"reason"}, constLabels,
+		),
+		latency: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "operation_duration_seconds"),
+			"Duration of the cache operations.",
+			[]string{opLabel}, constLabels,
+		),
+		sizes: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subs, "object_size_bytes"),
+			"Size of the items put in the cache.",
+			nil, constLabels,
+		),
 	}
 }
 
@@ -108,6 +176,16 @@ func (c *collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.sets
 	ch <- c.setsBytes
 	ch <- c.deletes
+	ch <- c.reclaimed
+	ch <- c.rawBytes
+	ch <- c.stored
+	ch <- c.corrupted
+	ch <- c.used
+	ch <- c.capacity
+	ch <- c.items
+	ch <- c.evicted
+	ch <- c.latency
+	ch <- c.sizes
 }
 
 func (c *collector) Collect(ch chan<- prometheus.Metric) {
@@ -120,4 +198,103 @@ func (c *collector) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(c.sets, prometheus.CounterValue, float64(s.Sets))
 	ch <- prometheus.MustNewConstMetric(c.setsBytes, prometheus.CounterValue, float64(s.SetsBytes))
 	ch <- prometheus.MustNewConstMetric(c.deletes, prometheus.CounterValue, float64(s.Deletes))
+	ch <- prometheus.MustNewConstMetric(c.reclaimed, prometheus.CounterValue, float64(s.Reclaimed))
+	ch <- prometheus.MustNewConstMetric(c.rawBytes, prometheus.CounterValue, float64(s.RawBytes))
+	ch <- prometheus.MustNewConstMetric(c.stored, prometheus.CounterValue, float64(s.Stored))
+	ch <- prometheus.MustNewConstMetric(c.corrupted, prometheus.CounterValue, float64(s.Corrupted))
+	ch <- prometheus.MustNewConstMetric(c.used, prometheus.GaugeValue, float64(s.Used))
+	ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(s.Capacity))
+	ch <- prometheus.MustNewConstMetric(c.items, prometheus.GaugeValue, float64(s.Items))
+	ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(s.Evicted), "capacity")
+	ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(s.Expired), "expired")
+	ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(s.Purged), "purged")
+	ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(s.Discarded), "corrupted")
+	ch <- histogram(c.latency, s.GetLatency, float64(time.Second), "get")
+	ch <- histogram(c.latency, s.SetLatency, float64(time.Second), "set")
+	ch <- histogram(c.latency, s.DeleteLatency, float64(time.Second), "delete")
+	ch <- histogram(c.sizes, s.Sizes, 1)
+}
+
+type requestCollector struct {
+	monitor       *getcached.RequestMonitor
+	requests      *prometheus.Desc
+	duration      *prometheus.Desc
+	originReqs    *prometheus.Desc
+	originFetches *prometheus.Desc
+	originErrors  *prometheus.Desc
+	originLatency *prometheus.Desc
+}
+
+func newRequestCollector(monitor *getcached.RequestMonitor) *requestCollector {
+	return &requestCollector{
+		monitor: monitor,
+		requests: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "http", "requests_total"),
+			"Total requests served by status code.",
+			[]string{"code"}, nil,
+		),
+		duration: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "http", "request_duration_seconds"),
+			"Duration of the requests served by cache status.",
+			[]string{"cache_status"}, nil,
+		),
+		originReqs: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "origin", "requests_total"),
+			"Total requests served by origin host.",
+			[]string{"host"}, nil,
+		),
+		originFetches: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "origin", "fetches_total"),
+			"Total requests made to origin hosts.",
+			[]string{"host"}, nil,
+		),
+		originErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "origin", "errors_total"),
+			"Total requests to origin hosts failing or answered with a server error.",
+			[]string{"host"}, nil,
+		),
+		originLatency: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "origin", "fetch_duration_seconds"),
+			"Time to the origin response headers.",
+			[]string{"host"}, nil,
+		),
+	}
+}
+
+func (c *requestCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.requests
+	ch <- c.duration
+	ch <- c.originReqs
+	ch <- c.originFetches
+	ch <- c.originErrors
+	ch <- c.originLatency
+}
+
+func (c *requestCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.monitor.Stats()
+
+	for code, n := range s.Codes {
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(n), strconv.Itoa(code))
+	}
+	for status, d := range s.Durations {
+		ch <- histogram(c.duration, d, float64(time.Second), string(status))
+	}
+	for host, o := range s.Origins {
+		ch <- prometheus.MustNewConstMetric(c.originReqs, prometheus.CounterValue, float64(o.Requests), host)
+		ch <- prometheus.MustNewConstMetric(c.originFetches, prometheus.CounterValue, float64(o.Fetches), host)
+		ch <- prometheus.MustNewConstMetric(c.originErrors, prometheus.CounterValue, float64(o.Errors), host)
+		ch <- histogram(c.originLatency, o.Latency, float64(time.Second), host)
+	}
+}
+
+// histogram converts a distribution to a Prometheus histogram,
+// dividing its values by unit.
+func histogram(desc *prometheus.Desc, d getcached.Distribution, unit float64, labelValues ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(d.Bounds))
+	var cum uint64
+	for i, bound := range d.Bounds {
+		cum += uint64(d.Counts[i])
+		buckets[float64(bound)/unit] = cum
+	}
+	return prometheus.MustNewConstHistogram(desc, uint64(d.Count), float64(d.Sum)/unit, buckets, labelValues...)
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/lru"
+	"github.com/mikegleasonjr/getcached/snapshot"
+)
+
+// diskTier opens the disk tier configured by the flags.
+// It must not be in use by a running proxy.
+func diskTier() httpcache.Cache {
+	store := configureDiskStore(*diskbackend, *diskdir, *diskdepth, *diskdedup, 0)
+	return lru.New(lru.WithCache(configureStorage(store, *compress)), lru.WithSize(uint64(*disksize)))
+}
+
+// exportDisk writes the entries of the disk tier to an
+// archive, or stdout if archive is "-".
+func exportDisk(archive string) bool {
+	w := os.Stdout
+	if archive != "-" {
+		f, err := os.Create(archive)
+		if err != nil {
+			stderr.Println(err)
+			return false
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := snapshot.Export(w, diskTier())
+	if err == nil && w != os.Stdout {
+		err = w.Close()
+	}
+	if err != nil {
+		stderr.Println(err)
+		return false
+	}
+
+	stderr.Printf("exported %d entries", n)
+	return true
+}
+
+// importDisk stores the entries of an archive, or stdin if
+// archive is "-", in the disk tier.
+func importDisk(archive string) bool {
+	var r io.Reader = os.Stdin
+	if archive != "-" {
+		f, err := os.Open(archive)
+		if err != nil {
+			stderr.Println(err)
+			return false
+		}
+		defer f.Close()
+		r = f
+	}
+
+	stats, err := snapshot.Import(r, diskTier())
+	stderr.Printf("imported %d entries, skipped %d expired and %d invalid", stats.Imported, stats.Expired, stats.Invalid)
+	if err != nil {
+		stderr.Println(err)
+		return false
+	}
+
+	return true
+}
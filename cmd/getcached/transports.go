@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
@@ -32,13 +33,14 @@ func DefaultTransport() http.RoundTripper {
 }
 
 // BodySizeCheckerTransport replaces the response body with a
-// ReadCloser that returns an error when the body exceeds a certain
-// size.
-func BodySizeCheckerTransport(max int64, rt http.RoundTripper) http.RoundTripper {
+// ReadCloser that returns an error when the body exceeds the size
+// max points to, loaded atomically for every response so it can
+// change while running.
+func BodySizeCheckerTransport(max *int64, rt http.RoundTripper) http.RoundTripper {
 	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
 		res, err := rt.RoundTrip(req)
 		if res != nil && res.Body != nil {
-			res.Body = &bodySizeChecker{RC: res.Body, N: max}
+			res.Body = &bodySizeChecker{RC: res.Body, N: atomic.LoadInt64(max)}
 		}
 		return res, err
 	})
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/mikegleasonjr/getcached"
+)
+
+// warm prefetches the URLs listed by source through a fleet
+// of proxies. It returns false if some URLs failed.
+func warm(proxies []string, concurrency int, rate float64, source string) bool {
+	urls, err := readURLs(source)
+	if err != nil {
+		stderr.Printf("reading %s: %v", source, err)
+		return false
+	}
+
+	c := getcached.NewClient(getcached.WithPrefetchConcurrency(concurrency), getcached.WithPrefetchRate(rate))
+	c.Set(proxies...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigchan
+		cancel()
+	}()
+
+	stats, err := c.Prefetch(ctx, urls)
+	stdout.Printf("warmed %d urls: %d cached, %d uncacheable, %d failed", len(urls), stats.Cached, stats.Uncacheable, stats.Failed)
+	if err != nil {
+		stderr.Println(err)
+		return false
+	}
+
+	return stats.Failed == 0
+}
+
+// readURLs reads URLs from a file, a URL or stdin if source is "-".
+func readURLs(source string) ([]string, error) {
+	var r io.Reader = os.Stdin
+
+	switch {
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		res, err := http.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		r = res.Body
+	case source != "-":
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	return getcached.ReadURLs(r)
+}
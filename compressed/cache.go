@@ -0,0 +1,247 @@
+package compressed
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gregjones/httpcache"
+)
+
+// magic starts every value stored, followed by the version
+// of the format and the ID of the Codec of the value, or 0
+// if stored uncompressed. Values stored before compression
+// was enabled are serialized responses starting with "HTTP/"
+// and are returned as is; values starting with another magic
+// or version are misses.
+var magic = []byte("\x00gcz")
+
+const (
+	version    = 1
+	headerSize = 6
+)
+
+var defaultCodecs = map[string]Codec{
+	"text/*":                        Zstd,
+	"application/json":              Zstd,
+	"application/javascript":        Zstd,
+	"application/xml":               Zstd,
+	"image/svg+xml":                 Zstd,
+	"*/*+json":                      Zstd,
+	"*/*+xml":                       Zstd,
+	"application/wasm":              Snappy,
+	"application/vnd.ms-fontobject": Snappy,
+	"font/otf":                      Snappy,
+	"font/ttf":                      Snappy,
+}
+
+// Cache is a cache decorator compressing values
+// according to the content type of the response
+// they hold. Responses already having a content
+// encoding are stored as is.
+type Cache struct {
+	c      httpcache.Cache
+	codecs map[string]Codec // by media type
+	ids    map[byte]Codec
+	raw    int64 // bytes given to Set, accessed atomically
+	stored int64 // bytes stored, accessed atomically
+}
+
+// New creates a Cache.
+func New(options ...func(*Cache)) *Cache {
+	c := &Cache{
+		c:      httpcache.NewMemoryCache(),
+		codecs: map[string]Codec{},
+		ids:    map[byte]Codec{},
+	}
+
+	for _, codec := range []Codec{Gzip, Deflate, Zstd, Snappy, Brotli} {
+		c.ids[codec.ID()] = codec
+	}
+	for mediaType, codec := range defaultCodecs {
+		WithCodec(mediaType, codec)(c)
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// Get implements httpcache.Cache.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	b, ok := c.c.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	if !bytes.HasPrefix(b, magic) {
+		if len(b) > 0 && b[0] == magic[0] {
+			return nil, false
+		}
+		return b, true
+	}
+	if len(b) < headerSize || b[len(magic)] != version {
+		return nil, false
+	}
+
+	id := b[len(magic)+1]
+	if id == 0 {
+		return b[headerSize:], true
+	}
+	codec, ok := c.ids[id]
+	if !ok {
+		return nil, false
+	}
+
+	r, err := codec.NewReader(bytes.NewReader(b[headerSize:]))
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+
+	resp, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+
+	return resp, true
+}
+
+// Set implements httpcache.Cache.
+func (c *Cache) Set(key string, resp []byte) {
+	c.SetEncoded(key, c.Encode(resp))
+}
+
+// Delete implements httpcache.Cache.
+func (c *Cache) Delete(key string) {
+	c.c.Delete(key)
+}
+
+// Encode compresses a value the way Set would store it.
+func (c *Cache) Encode(resp []byte) []byte {
+	enc := c.encode(resp)
+	atomic.AddInt64(&c.raw, int64(len(resp)))
+	atomic.AddInt64(&c.stored, int64(len(enc)))
+	return enc
+}
+
+// SetEncoded stores a value previously returned from Encode.
+func (c *Cache) SetEncoded(key string, enc []byte) {
+	c.c.Set(key, enc)
+}
+
+// Compression returns the total number of bytes given
+// to the cache and the number of bytes actually stored.
+func (c *Cache) Compression() (raw, stored int64) {
+	return atomic.LoadInt64(&c.raw), atomic.LoadInt64(&c.stored)
+}
+
+// Range calls fn with the keys and stored sizes of the
+// values of the underlying cache, if it has a Range method
+// like lru.Ranger.
+func (c *Cache) Range(fn func(key string, size int64)) {
+	if r, ok := c.c.(interface {
+		Range(fn func(key string, size int64))
+	}); ok {
+		r.Range(fn)
+	}
+}
+
+// Unwrap returns the underlying cache.
+func (c *Cache) Unwrap() httpcache.Cache {
+	return c.c
+}
+
+func (c *Cache) encode(resp []byte) []byte {
+	if codec := c.codecFor(resp); codec != nil {
+		buf := bytes.NewBuffer(make([]byte, 0, len(resp)/2))
+		buf.Write(header(codec.ID()))
+
+		w := codec.NewWriter(buf)
+		_, err := w.Write(resp)
+		if err == nil {
+			err = w.Close()
+		}
+		if err == nil && buf.Len() < headerSize+len(resp) {
+			return buf.Bytes()
+		}
+	}
+
+	return append(header(0), resp...)
+}
+
+func header(id byte) []byte {
+	return append(append(make([]byte, 0, headerSize), magic...), version, id)
+}
+
+func (c *Cache) codecFor(resp []byte) Codec {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+	if err != nil {
+		return nil
+	}
+
+	if ce := res.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return nil
+	}
+
+	return match(c.codecs, res.Header.Get("Content-Type"))
+}
+
+// Compressible tells if responses with a content type are
+// compressed by default.
+func Compressible(contentType string) bool {
+	return match(defaultCodecs, contentType) != nil
+}
+
+func match(codecs map[string]Codec, contentType string) Codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	if codec, ok := codecs[mediaType]; ok {
+		return codec
+	}
+
+	if slash := strings.Index(mediaType, "/"); slash >= 0 {
+		if codec, ok := codecs[mediaType[:slash]+"/*"]; ok {
+			return codec
+		}
+	}
+
+	if plus := strings.LastIndex(mediaType, "+"); plus >= 0 {
+		return codecs["*/*"+mediaType[plus:]]
+	}
+
+	return nil
+}
+
+// WithCache configures a Cache to use a specific
+// httpcache.Cache as its underlying storage.
+func WithCache(hc httpcache.Cache) func(*Cache) {
+	return func(c *Cache) {
+		c.c = hc
+	}
+}
+
+// WithCodec configures a Cache to compress responses of
+// a media type with a specific Codec. The media type can
+// be a wildcard such as "text/*" or a structured syntax
+// suffix such as "*/*+json". A nil Codec disables the
+// compression of the media type.
+func WithCodec(mediaType string, codec Codec) func(*Cache) {
+	return func(c *Cache) {
+		if codec == nil {
+			delete(c.codecs, mediaType)
+			return
+		}
+		c.codecs[mediaType] = codec
+		c.ids[codec.ID()] = codec
+	}
+}
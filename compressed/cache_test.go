@@ -0,0 +1,142 @@
+package compressed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+	"github.com/gregjones/httpcache/test"
+	"github.com/mikegleasonjr/getcached/cachetest"
+)
+
+func TestCache(t *testing.T) {
+	test.Cache(t, New())
+}
+
+func TestCompression(t *testing.T) {
+	body := strings.Repeat(`{"hello":"world"}`, 100)
+	tests := []struct {
+		header     http.Header
+		compressed bool
+		codec      byte
+	}{
+		{http.Header{"Content-Type": {"application/json"}}, true, Zstd.ID()},
+		{http.Header{"Content-Type": {"text/html; charset=utf-8"}}, true, Zstd.ID()},
+		{http.Header{"Content-Type": {"application/ld+json"}}, true, Zstd.ID()},
+		{http.Header{"Content-Type": {"font/ttf"}}, true, Snappy.ID()},
+		{http.Header{"Content-Type": {"image/png"}}, false, 0},
+		{http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"gzip"}}, false, 0},
+		{http.Header{}, false, 0},
+	}
+
+	for i, tc := range tests {
+		storage := httpcache.NewMemoryCache()
+		c := New(WithCache(storage))
+		resp := response(tc.header, body)
+
+		c.Set("key", resp)
+
+		stored, _ := storage.Get("key")
+		if got, want := len(stored) < len(resp), tc.compressed; got != want {
+			t.Errorf("test %d: unexpected compression: got %t, want %t", i, got, want)
+		}
+		if got := stored[headerSize-1]; got != tc.codec {
+			t.Errorf("test %d: unexpected codec: got %d, want %d", i, got, tc.codec)
+		}
+
+		got, ok := c.Get("key")
+		if !ok {
+			t.Fatalf("test %d: expected key %q to be found in cache", i, "key")
+		}
+		if !bytes.Equal(got, resp) {
+			t.Errorf("test %d: value mismatch: got %q, want %q", i, got, resp)
+		}
+
+		raw, size := c.Compression()
+		if raw != int64(len(resp)) || size != int64(len(stored)) {
+			t.Errorf("test %d: unexpected compression stats: got %d/%d, want %d/%d", i, raw, size, len(resp), len(stored))
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	resp := response(http.Header{"Content-Type": {"text/plain"}}, strings.Repeat("a", 1000))
+
+	for _, codec := range []Codec{Gzip, Deflate, Zstd, Snappy, Brotli} {
+		storage := httpcache.NewMemoryCache()
+		New(WithCache(storage), WithCodec("text/*", codec)).Set("key", resp)
+
+		stored, _ := storage.Get("key")
+		if !bytes.HasPrefix(stored, header(codec.ID())) {
+			t.Errorf("unexpected header of codec %d: got %q", codec.ID(), stored[:headerSize])
+		}
+		if len(stored) >= len(resp) {
+			t.Errorf("unexpected size of codec %d: got %d, want less than %d", codec.ID(), len(stored), len(resp))
+		}
+
+		got, ok := New(WithCache(storage)).Get("key")
+		if !ok || !bytes.Equal(got, resp) {
+			t.Errorf("expected value of codec %d to be decoded by another cache", codec.ID())
+		}
+	}
+}
+
+func TestHeader(t *testing.T) {
+	resp := response(http.Header{"Content-Type": {"text/plain"}}, "hello")
+
+	tests := []struct {
+		name   string
+		stored []byte
+		want   []byte
+		ok     bool
+	}{
+		{"uncompressed", append([]byte("\x00gcz\x01\x00"), resp...), resp, true},
+		{"legacy", resp, resp, true},
+		{"magic", []byte("\x00gcz\x01\x00\x00gcz\x01\x03"), []byte("\x00gcz\x01\x03"), true},
+		{"version", append([]byte("\x00gcz\x02\x00"), resp...), nil, false},
+		{"codec", append([]byte("\x00gcz\x01\x09"), resp...), nil, false},
+		{"corrupted", []byte("\x00gcz\x01\x03garbage"), nil, false},
+		{"truncated", []byte("\x00gcz\x01"), nil, false},
+		{"foreign", []byte("\x00\x01garbage"), nil, false},
+	}
+
+	for _, test := range tests {
+		storage := httpcache.NewMemoryCache()
+		storage.Set("key", test.stored)
+
+		got, ok := New(WithCache(storage)).Get("key")
+		if ok != test.ok || !bytes.Equal(got, test.want) {
+			t.Errorf("unexpected value of %s: got %q/%t, want %q/%t", test.name, got, ok, test.want, test.ok)
+		}
+	}
+
+	storage := httpcache.NewMemoryCache()
+	c := New(WithCache(storage))
+	c.Set("key", []byte("\x00gcz\x01\x03not compressed"))
+	if got, _ := c.Get("key"); string(got) != "\x00gcz\x01\x03not compressed" {
+		t.Errorf("unexpected value looking compressed: got %q", got)
+	}
+}
+
+func response(header http.Header, body string) []byte {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+	b, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestConformance(t *testing.T) {
+	cachetest.RunConformance(t, func() httpcache.Cache { return New() })
+}
@@ -0,0 +1,145 @@
+package compressed
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec compresses and decompresses values. Its ID
+// is stored along with compressed values so it must
+// be unique, never change and not be 0, which stands
+// for values stored uncompressed.
+type Codec interface {
+	ID() byte
+	NewWriter(w io.Writer) io.WriteCloser
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip is a Codec using gzip compression.
+var Gzip Codec = gzipCodec{}
+
+// Deflate is a Codec using raw deflate compression.
+var Deflate Codec = deflateCodec{}
+
+// Zstd is a Codec using Zstandard compression, compressing
+// better than gzip and decompressing faster.
+var Zstd Codec = wholeCodec{id: 3, compress: zstdCompress, decompress: zstdDecompress}
+
+// Snappy is a Codec using Snappy compression in its framing
+// format, compressing less but faster than the others.
+var Snappy Codec = snappyCodec{}
+
+// Brotli is a Codec using Brotli compression, the br content
+// coding of browsers, compressing text better than gzip.
+var Brotli Codec = brotliCodec{}
+
+var (
+	// both are safe for concurrent use on whole values
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1)) // only fails on bad options
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+)
+
+func zstdCompress(src []byte) []byte {
+	return zstdEncoder.EncodeAll(src, nil)
+}
+
+func zstdDecompress(src []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(src, nil)
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte { return 1 }
+
+func (gzipCodec) NewWriter(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type deflateCodec struct{}
+
+func (deflateCodec) ID() byte { return 2 }
+
+func (deflateCodec) NewWriter(w io.Writer) io.WriteCloser {
+	fw, _ := flate.NewWriter(w, flate.DefaultCompression) // only fails on bad level
+	return fw
+}
+
+func (deflateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) ID() byte { return 4 }
+
+func (snappyCodec) NewWriter(w io.Writer) io.WriteCloser {
+	return snappy.NewBufferedWriter(w)
+}
+
+func (snappyCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(snappy.NewReader(r)), nil
+}
+
+type brotliCodec struct{}
+
+func (brotliCodec) ID() byte { return 5 }
+
+func (brotliCodec) NewWriter(w io.Writer) io.WriteCloser {
+	return brotli.NewWriter(w)
+}
+
+func (brotliCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(brotli.NewReader(r)), nil
+}
+
+// wholeCodec is a Codec compressing and decompressing whole
+// values in memory.
+type wholeCodec struct {
+	id         byte
+	compress   func(src []byte) []byte
+	decompress func(src []byte) ([]byte, error)
+}
+
+func (c wholeCodec) ID() byte { return c.id }
+
+func (c wholeCodec) NewWriter(w io.Writer) io.WriteCloser {
+	return &wholeWriter{w: w, compress: c.compress}
+}
+
+func (c wholeCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = c.decompress(b); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// wholeWriter compresses what is written to it on Close.
+type wholeWriter struct {
+	w        io.Writer
+	compress func(src []byte) []byte
+	buf      bytes.Buffer
+}
+
+func (w *wholeWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *wholeWriter) Close() error {
+	_, err := w.w.Write(w.compress(w.buf.Bytes()))
+	return err
+}
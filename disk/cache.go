@@ -1,24 +1,50 @@
 package disk
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mikegleasonjr/getcached/freshness"
 )
 
 const (
-	defaultDir = "/tmp"
+	defaultDir    = "/tmp"
+	blobsDir      = "blobs"
+	quarantineDir = "quarantine"
+	depthFile     = "depth" // records the depth of the layout
 )
 
 // Cache caches requests to disk.
 type Cache struct {
-	dir   string
-	mu    sync.RWMutex // guards mus
-	locks map[string]*lock
+	dir       string
+	mu        sync.RWMutex // guards mus
+	locks     map[string]*lock
+	interval  time.Duration // janitor sweep interval, 0 when disabled
+	grace     time.Duration // time kept past an entry's stale window
+	reclaimed int64         // bytes removed by sweeps, accessed atomically
+	done      chan struct{}
+	closeOnce sync.Once
+	dedup     bool
+	depth     int            // levels of subdirectories entries are spread into
+	refsMu    sync.Mutex     // guards refs and blob files
+	refs      map[string]int // blob references by content hash
+	corrupted int64          // entries quarantined, accessed atomically
+	scrub     time.Duration  // pause between entries verified by the scrubber
+	scrubbing bool
 }
 
 type lock struct {
@@ -30,7 +56,7 @@ type lock struct {
 // New creates a Cache backed by a directory.
 // Panics is directory does not exists.
 func New(options ...func(*Cache)) *Cache {
-	c := &Cache{dir: defaultDir, locks: map[string]*lock{}}
+	c := &Cache{dir: defaultDir, locks: map[string]*lock{}, done: make(chan struct{}), refs: map[string]int{}}
 
 	for _, option := range options {
 		option(c)
@@ -40,6 +66,24 @@ func New(options ...func(*Cache)) *Cache {
 		panic(fmt.Sprintf("%q does not exists", c.dir))
 	}
 
+	if err := c.migrate(); err != nil {
+		panic(err)
+	}
+
+	if c.dedup {
+		if err := c.loadRefs(); err != nil {
+			panic(err)
+		}
+	}
+
+	if c.interval > 0 {
+		go c.janitor()
+	}
+
+	if c.scrubbing {
+		go c.scrubber()
+	}
+
 	return c
 }
 
@@ -47,27 +91,74 @@ func New(options ...func(*Cache)) *Cache {
 func (c *Cache) Get(key string) ([]byte, bool) {
 	fullpath := c.fullPath(key)
 
-	l := c.getLock(key)
+	l := c.getLock(fullpath)
 	defer c.releaseLock(l)
 
 	l.RLock()
-	defer l.RUnlock()
+	value, err := c.read(fullpath, key)
+	l.RUnlock()
 
+	if err == ErrCorrupted {
+		c.quarantine(fullpath, key)
+	}
+
+	return value, err == nil
+}
+
+// read reads and verifies an entry. The key is
+// not verified when empty.
+func (c *Cache) read(fullpath, key string) ([]byte, error) {
 	b, err := ioutil.ReadFile(fullpath)
-	return b, err == nil
+	if err != nil {
+		return nil, err
+	}
+
+	m, value, ok, err := parseMeta(b)
+	if !ok || err != nil {
+		return nil, ErrCorrupted
+	}
+	if key == "" && c.fullPath(m.Key) != fullpath {
+		return nil, ErrCorrupted
+	}
+	if key != "" && m.Key != key {
+		return nil, errCollision
+	}
+
+	if m.Ref != "" {
+		body, err := ioutil.ReadFile(c.blobPath(m.Ref))
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+		value = append(value, body...)
+	}
+
+	if !m.Verify(value) {
+		return nil, ErrCorrupted
+	}
+
+	return value, nil
 }
 
 // Set saves a response to the cache as key.
 func (c *Cache) Set(key string, resp []byte) {
 	fullpath := c.fullPath(key)
 
-	l := c.getLock(key)
+	l := c.getLock(fullpath)
 	defer c.releaseLock(l)
 
 	l.Lock()
 	defer l.Unlock()
 
-	err := ioutil.WriteFile(fullpath, resp, 0644)
+	if err := c.mkdir(fullpath); err != nil {
+		return
+	}
+
+	if c.dedup {
+		c.setRef(fullpath, key, resp)
+		return
+	}
+
+	err := ioutil.WriteFile(fullpath, append(newMeta(key, resp).bytes(), resp...), 0644)
 	if err != nil {
 		os.Remove(fullpath)
 	}
@@ -77,30 +168,377 @@ func (c *Cache) Set(key string, resp []byte) {
 func (c *Cache) Delete(key string) {
 	fullpath := c.fullPath(key)
 
-	l := c.getLock(key)
+	l := c.getLock(fullpath)
+	defer c.releaseLock(l)
+
+	l.Lock()
+	defer l.Unlock()
+
+	c.remove(fullpath)
+}
+
+// Walk calls fn with the header of every entry in the cache,
+// stopping at the first error. Entries without headers, which
+// are corrupted, are skipped.
+func (c *Cache) Walk(fn func(Meta) error) error {
+	for _, fullpath := range c.files(c.dir, ".cache") {
+		m, ok, err := c.stat(fullpath)
+		if err != nil || !ok {
+			continue
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range calls fn with the key and size of every entry, from
+// the least to the most recently stored. Entries without
+// headers, which are corrupted, are skipped.
+func (c *Cache) Range(fn func(key string, size int64)) {
+	metas := []Meta{}
+	c.Walk(func(m Meta) error {
+		metas = append(metas, m)
+		return nil
+	})
+
+	sort.Slice(metas, func(i, j int) bool { return metas[i].StoredAt.Before(metas[j].StoredAt) })
+
+	for _, m := range metas {
+		fn(m.Key, m.Size)
+	}
+}
+
+func (c *Cache) stat(fullpath string) (Meta, bool, error) {
+	l := c.getLock(fullpath)
+	defer c.releaseLock(l)
+
+	l.RLock()
+	defer l.RUnlock()
+
+	f, err := os.Open(fullpath)
+	if err != nil {
+		return Meta{}, false, err
+	}
+	defer f.Close()
+
+	return readMeta(bufio.NewReader(f))
+}
+
+// Sweep walks the cache directory and removes the entries whose
+// stale window ended more than the configured grace period ago.
+func (c *Cache) Sweep() {
+	deadline := time.Now().Add(-c.grace)
+
+	for _, fullpath := range c.files(c.dir, ".cache") {
+		c.sweep(fullpath, deadline)
+	}
+}
+
+func (c *Cache) sweep(fullpath string, deadline time.Time) {
+	l := c.getLock(fullpath)
 	defer c.releaseLock(l)
 
 	l.Lock()
 	defer l.Unlock()
 
-	os.Remove(fullpath)
+	f, err := os.Open(fullpath)
+	if err != nil {
+		return
+	}
+	r := bufio.NewReader(f)
+	readMeta(r)
+	fr, ok := freshness.Read(r)
+	f.Close()
+
+	if !ok || !fr.StaleUntil().Before(deadline) {
+		return
+	}
+	atomic.AddInt64(&c.reclaimed, c.remove(fullpath))
+}
+
+// Reclaimed returns the number of bytes removed by sweeps.
+func (c *Cache) Reclaimed() int64 {
+	return atomic.LoadInt64(&c.reclaimed)
+}
+
+// Close stops the janitor, if any.
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *Cache) janitor() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Sweep()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// setRef stores the body of a response in a blob named after
+// its content, shared with other keys having the same body, and
+// the rest of the response in a file pointing to the blob.
+func (c *Cache) setRef(fullpath, key string, resp []byte) {
+	head, body := resp, []byte(nil)
+	if i := bytes.Index(resp, []byte("\r\n\r\n")); i >= 0 {
+		head, body = resp[:i+4], resp[i+4:]
+	}
+
+	h := sha256.Sum256(body)
+	sum := hex.EncodeToString(h[:])
+	old, _ := c.readRef(fullpath)
+
+	if err := c.retain(sum, body); err != nil {
+		return
+	}
+
+	m := newMeta(key, resp)
+	m.Ref = sum
+
+	err := ioutil.WriteFile(fullpath, append(m.bytes(), head...), 0644)
+	if err != nil {
+		os.Remove(fullpath)
+		c.release(sum)
+	}
+	if old != "" {
+		c.release(old)
+	}
+}
+
+// remove removes an entry and the blob it points to if it
+// was its last reference. It returns the bytes freed.
+func (c *Cache) remove(fullpath string) (freed int64) {
+	sum, _ := c.readRef(fullpath)
+	if s, err := os.Stat(fullpath); err == nil && os.Remove(fullpath) == nil {
+		freed = s.Size()
+		if sum != "" && c.dedup {
+			freed += c.release(sum)
+		}
+	}
+	return
+}
+
+// retain adds a reference to a blob, writing it if needed.
+func (c *Cache) retain(sum string, body []byte) error {
+	c.refsMu.Lock()
+	defer c.refsMu.Unlock()
+
+	if c.refs[sum] == 0 {
+		if err := c.mkdir(c.blobPath(sum)); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(c.blobPath(sum), body, 0644); err != nil {
+			os.Remove(c.blobPath(sum))
+			return err
+		}
+	}
+	c.refs[sum]++
+
+	return nil
+}
+
+// release removes a reference to a blob, removing it when
+// unreferenced. It returns the bytes freed.
+func (c *Cache) release(sum string) (freed int64) {
+	c.refsMu.Lock()
+	defer c.refsMu.Unlock()
+
+	c.refs[sum]--
+	if c.refs[sum] > 0 {
+		return 0
+	}
+
+	delete(c.refs, sum)
+	if s, err := os.Stat(c.blobPath(sum)); err == nil && os.Remove(c.blobPath(sum)) == nil {
+		freed = s.Size()
+	}
+	return
+}
+
+// readRef returns the blob an entry points to, if any.
+func (c *Cache) readRef(fullpath string) (string, error) {
+	f, err := os.Open(fullpath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	m, _, err := readMeta(bufio.NewReader(f))
+	return m.Ref, err
+}
+
+// loadRefs counts blob references from existing entries and
+// removes unreferenced blobs, left over by a crash.
+func (c *Cache) loadRefs() error {
+	if err := os.MkdirAll(path.Join(c.dir, blobsDir), 0755); err != nil {
+		return err
+	}
+
+	for _, fullpath := range c.files(c.dir, ".cache") {
+		if sum, _ := c.readRef(fullpath); sum != "" {
+			c.refs[sum]++
+		}
+	}
+
+	for _, blob := range c.files(path.Join(c.dir, blobsDir), ".blob") {
+		if c.refs[strings.TrimSuffix(path.Base(blob), ".blob")] == 0 {
+			os.Remove(blob)
+		}
+	}
+
+	return nil
+}
+
+func (c *Cache) blobPath(sum string) string {
+	return c.shard(path.Join(c.dir, blobsDir), sum+".blob")
+}
+
+// migrate moves the entries and blobs of another layout, flat
+// unless the depth file records another, to the configured depth,
+// removing the subdirectories a deeper layout leaves empty. Only
+// files named and placed as that layout did are moved, leaving
+// foreign files alone. A directory without depth file was written
+// before entries had headers: its entries without one are removed
+// once, as they can neither be verified nor attributed to a key.
+func (c *Cache) migrate() error {
+	marker := path.Join(c.dir, depthFile)
+	from := 0
+	b, err := ioutil.ReadFile(marker)
+	switch {
+	case err == nil:
+		if from, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			return fmt.Errorf("invalid %s: %s", marker, err)
+		}
+		if from == c.depth {
+			return nil
+		}
+	case os.IsNotExist(err):
+		c.removeLegacy()
+	default:
+		return err
+	}
+
+	roots := []struct {
+		dir, ext string
+		size     int // of the hexadecimal hash naming files
+	}{
+		{c.dir, ".cache", 2 * md5.Size},
+		{path.Join(c.dir, blobsDir), ".blob", 2 * sha256.Size},
+	}
+
+	for _, root := range roots {
+		matches, _ := filepath.Glob(path.Join(root.dir, strings.Repeat("??/", from)+"*"+root.ext))
+		for _, p := range matches {
+			filename := path.Base(p)
+			if !hashed(filename, root.ext, root.size) || p != shard(root.dir, filename, from) {
+				continue
+			}
+			to := c.shard(root.dir, filename)
+			if err := c.mkdir(to); err != nil {
+				return err
+			}
+			if err := os.Rename(p, to); err != nil {
+				return err
+			}
+		}
+
+		// deepest first, only empty directories can be removed
+		for level := from; level > c.depth; level-- {
+			dirs, _ := filepath.Glob(path.Join(root.dir, strings.Repeat("??/", level)))
+			for _, dir := range dirs {
+				os.Remove(dir)
+			}
+		}
+	}
+
+	return ioutil.WriteFile(marker, []byte(strconv.Itoa(c.depth)+"\n"), 0644)
+}
+
+// removeLegacy removes the flat entries without headers.
+func (c *Cache) removeLegacy() {
+	matches, _ := filepath.Glob(path.Join(c.dir, "*.cache"))
+	for _, p := range matches {
+		if !hashed(path.Base(p), ".cache", 2*md5.Size) {
+			continue
+		}
+		if _, ok, err := c.stat(p); !ok && err == nil {
+			os.Remove(p)
+		}
+	}
+}
+
+// hashed tells if filename is a lowercase hexadecimal hash of
+// size characters followed by ext, as the cache names files.
+func hashed(filename, ext string, size int) bool {
+	name := strings.TrimSuffix(filename, ext)
+	if len(name) != size || name+ext != filename {
+		return false
+	}
+	b, err := hex.DecodeString(name)
+	return err == nil && hex.EncodeToString(b) == name
+}
+
+// files returns the files of dir and its subdirectories having
+// an extension, skipping blobs and quarantined entries.
+func (c *Cache) files(dir, ext string) []string {
+	files := []string{}
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+		case info.IsDir() && p != dir && (info.Name() == blobsDir || info.Name() == quarantineDir):
+			return filepath.SkipDir
+		case !info.IsDir() && strings.HasSuffix(p, ext):
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files
+}
+
+// shard returns the path of a file in dir, nested in
+// subdirectories named after its first characters.
+func (c *Cache) shard(dir, filename string) string {
+	return shard(dir, filename, c.depth)
+}
+
+func shard(dir, filename string, depth int) string {
+	elems := []string{dir}
+	for i := 0; i < depth && 2*i+2 < len(filename); i++ {
+		elems = append(elems, filename[2*i:2*i+2])
+	}
+	return path.Join(append(elems, filename)...)
+}
+
+func (c *Cache) mkdir(fullpath string) error {
+	if c.depth == 0 {
+		return nil
+	}
+	return os.MkdirAll(path.Dir(fullpath), 0755)
 }
 
 func (c *Cache) fullPath(key string) string {
 	h := md5.New()
 	h.Write([]byte(key))
 	filename := hex.EncodeToString(h.Sum(nil)) + ".cache"
-	return path.Join(c.dir, filename)
+	return c.shard(c.dir, filename)
 }
 
-func (c *Cache) getLock(key string) *lock {
+func (c *Cache) getLock(fullpath string) *lock {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	l, ok := c.locks[key]
+	l, ok := c.locks[fullpath]
 	if !ok {
-		l = &lock{key: key}
-		c.locks[key] = l
+		l = &lock{key: fullpath}
+		c.locks[fullpath] = l
 	}
 	l.refs++
 
@@ -123,3 +561,33 @@ func WithDir(dir string) func(*Cache) {
 		c.dir = dir
 	}
 }
+
+// WithJanitor configures a Cache to sweep expired entries
+// every interval. Entries are removed once their stale window
+// ended more than grace ago.
+func WithJanitor(interval, grace time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.interval = interval
+		c.grace = grace
+	}
+}
+
+// WithDedup configures a Cache to store response bodies once per
+// distinct content, in a blobs subdirectory, with entries pointing
+// to them. It changes the on-disk layout of entries.
+func WithDedup() func(*Cache) {
+	return func(c *Cache) {
+		c.dedup = true
+	}
+}
+
+// WithDepth configures a Cache to spread entries into depth
+// levels of subdirectories named after the first characters of
+// their filename, as in ab/cd/abcd...cache for a depth of 2.
+// Entries of another layout are moved to the new one on
+// creation.
+func WithDepth(depth int) func(*Cache) {
+	return func(c *Cache) {
+		c.depth = depth
+	}
+}
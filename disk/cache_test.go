@@ -3,10 +3,19 @@ package disk
 import (
 	"bytes"
 	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
 	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gregjones/httpcache"
 	"github.com/gregjones/httpcache/test"
+	"github.com/mikegleasonjr/getcached/cachetest"
 )
 
 func TestCache(t *testing.T) {
@@ -18,6 +27,304 @@ func TestCache(t *testing.T) {
 	test.Cache(t, New(WithDir(dir)))
 }
 
+func TestDedup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	test.Cache(t, New(WithDir(dir), WithDedup()))
+
+	c := New(WithDir(dir), WithDedup())
+	resp1 := response(time.Now(), time.Hour)
+	resp2 := response(time.Now().Add(time.Second), time.Hour)
+	c.Set("key1", resp1)
+	c.Set("key2", resp2)
+	c.Set("key3", []byte("HTTP/1.1 200 OK\r\n\r\nother"))
+
+	if got, want := blobs(t, dir), 2; got != want {
+		t.Errorf("unexpected blobs count: got %d, want %d", got, want)
+	}
+
+	c.Delete("key1")
+	if got, ok := c.Get("key2"); !ok || !bytes.Equal(got, resp2) {
+		t.Errorf("value mismatch for %q: got %q, want %q", "key2", got, resp2)
+	}
+
+	c = New(WithDir(dir), WithDedup()) // reload references
+	c.Delete("key2")
+	if got, want := blobs(t, dir), 1; got != want {
+		t.Errorf("unexpected blobs count: got %d, want %d", got, want)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	c := New(WithDir(dir))
+	value := []byte("HTTP/1.1 200 OK\r\n\r\ncontent")
+
+	c.Set("key", value)
+	b, err := ioutil.ReadFile(c.fullPath("key"))
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+
+	m, payload, ok, err := parseMeta(b)
+	if !ok || err != nil {
+		t.Fatalf("unexpected header: %t, %v", ok, err)
+	}
+	if m.Key != "key" || m.Size != int64(len(value)) || !m.Verify(payload) || time.Since(m.StoredAt) > time.Minute {
+		t.Errorf("unexpected header: %+v", m)
+	}
+
+	keys := []string{}
+	c.Set("other", value)
+	c.Walk(func(m Meta) error {
+		keys = append(keys, m.Key)
+		return nil
+	})
+	sort.Strings(keys)
+	if got, want := strings.Join(keys, ","), "key,other"; got != want {
+		t.Errorf("unexpected keys: got %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name  string
+		entry []byte
+		hit   bool
+	}{
+		{"no header", value, false},
+		{"magic", append([]byte("gotcached/1"), newMeta("key", value).bytes()[len("getcached/1"):]...), false},
+		{"collision", append(newMeta("other", value).bytes(), value...), false},
+		{"corrupted", append(newMeta("key", value).bytes(), bytes.ToUpper(value)...), false},
+		{"truncated", append(newMeta("key", value).bytes(), value[1:]...), false},
+		{"bad header", []byte(metaMagic + "1\nkey\n\n"), false},
+	}
+
+	for _, test := range tests {
+		if err := ioutil.WriteFile(c.fullPath("key"), test.entry, 0644); err != nil {
+			t.Fatalf("unexpected error: %q", err)
+		}
+		if _, hit := c.Get("key"); hit != test.hit {
+			t.Errorf("%s: unexpected hit: got %t, want %t", test.name, hit, test.hit)
+		}
+	}
+	if got, want := c.Corrupted(), int64(5); got != want {
+		t.Errorf("unexpected corrupted entries: got %d, want %d", got, want)
+	}
+}
+
+func TestLargeEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// larger than the buffer reading headers
+	value := []byte("HTTP/1.1 200 OK\r\n\r\n" + strings.Repeat("0123456789", 1000))
+
+	for _, c := range []*Cache{New(WithDir(dir)), New(WithDir(dir), WithDedup())} {
+		c.Set("key", value)
+		if got, ok := c.Get("key"); !ok || !bytes.Equal(got, value) {
+			t.Errorf("unexpected value: got %d bytes, want %d", len(got), len(value))
+		}
+
+		b, _ := ioutil.ReadFile(c.fullPath("key"))
+		if _, payload, ok, err := parseMeta(b); !ok || err != nil || !bytes.HasPrefix(value, payload) {
+			t.Errorf("unexpected payload: got %d bytes (%t, %v)", len(payload), ok, err)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	c := New(WithDir(dir), WithDepth(1))
+	value := []byte("HTTP/1.1 200 OK\r\n\r\ncontent")
+
+	for _, key := range []string{"key1", "key2", "key3"} {
+		c.Set(key, value)
+	}
+	corrupt(t, c.fullPath("key1"))
+	corrupt(t, c.fullPath("key2"))
+
+	if _, ok := c.Get("key1"); ok {
+		t.Errorf("unexpected key %q in cache", "key1")
+	}
+	if got, want := c.Corrupted(), int64(1); got != want {
+		t.Errorf("unexpected corrupted entries: got %d, want %d", got, want)
+	}
+
+	c.Scrub()
+	if got, want := c.Corrupted(), int64(2); got != want {
+		t.Errorf("unexpected corrupted entries: got %d, want %d", got, want)
+	}
+
+	quarantined, _ := ioutil.ReadDir(path.Join(dir, quarantineDir))
+	if got, want := len(quarantined), 2; got != want {
+		t.Errorf("unexpected quarantined entries: got %d, want %d", got, want)
+	}
+
+	if _, ok := c.Get("key3"); !ok {
+		t.Errorf("expected key %q to be found in cache", "key3")
+	}
+}
+
+func corrupt(t *testing.T, fullpath string) {
+	b, err := ioutil.ReadFile(fullpath)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	b[len(b)-1] ^= 0xff
+	if err := ioutil.WriteFile(fullpath, b, 0644); err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	test.Cache(t, New(WithDir(dir), WithDepth(2)))
+
+	flat := New(WithDir(dir), WithDedup())
+	flat.Set("key", []byte("HTTP/1.1 200 OK\r\n\r\ncontent"))
+	flat.Set("other", []byte("HTTP/1.1 200 OK\r\n\r\ncontent"))
+
+	c := New(WithDir(dir), WithDedup(), WithDepth(2))
+	fullpath := c.fullPath("key")
+	if got, want := fullpath, path.Join(dir, "3c", "6e", "3c6e0b8a9c15224a8228b9a98ca1531d.cache"); got != want {
+		t.Errorf("unexpected path: got %q, want %q", got, want)
+	}
+
+	if _, err := os.Stat(fullpath); err != nil {
+		t.Errorf("expected entry to be migrated: %q", err)
+	}
+
+	for _, key := range []string{"key", "other"} {
+		if got, ok := c.Get(key); !ok || string(got) != "HTTP/1.1 200 OK\r\n\r\ncontent" {
+			t.Errorf("value mismatch for %q: got %q", key, got)
+		}
+	}
+
+	c.Delete("key")
+	c.Delete("other")
+	if files := c.files(dir, ""); len(files) != 1 || files[0] != path.Join(dir, depthFile) {
+		t.Errorf("unexpected files left: %v", files)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+
+	entry := "3c6e0b8a9c15224a8228b9a98ca1531d.cache"
+	legacy := "0123456789abcdef0123456789abcdef.cache"
+	value := []byte("HTTP/1.1 200 OK\r\n\r\nflat entry")
+	files := map[string]string{
+		entry:                                    string(append(newMeta("key", value).bytes(), value...)),
+		legacy:                                   "entry without header",
+		"sub/foo.cache":                          "foreign file",
+		"foo.cache":                              "foreign file",
+		"zz/" + entry:                            "misplaced entry",
+		"3C6E0B8A9C15224A8228B9A98CA1531D.cache": "uppercase hash",
+	}
+	for name, content := range files {
+		os.MkdirAll(path.Dir(path.Join(dir, name)), 0755)
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %q", err)
+		}
+	}
+
+	New(WithDir(dir), WithDepth(1))
+	files["3c/"+entry] = files[entry]
+	delete(files, entry)
+	delete(files, legacy)
+	for name, content := range files {
+		if b, err := ioutil.ReadFile(path.Join(dir, name)); err != nil || string(b) != content {
+			t.Errorf("unexpected content of %s after migrating: got %q (%v), want %q", name, b, err, content)
+		}
+	}
+	if _, err := os.Stat(path.Join(dir, entry)); !os.IsNotExist(err) {
+		t.Errorf("expected flat entry to be moved: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, legacy)); !os.IsNotExist(err) {
+		t.Errorf("expected entry without header to be removed: %v", err)
+	}
+
+	// the layout is unchanged, a new flat entry stays in place
+	ioutil.WriteFile(path.Join(dir, entry), []byte("new flat entry"), 0644)
+	New(WithDir(dir), WithDepth(1))
+	if b, _ := ioutil.ReadFile(path.Join(dir, entry)); string(b) != "new flat entry" {
+		t.Errorf("unexpected migration of an unchanged layout: got %q", b)
+	}
+
+	// entries of a deeper layout are moved back, emptied
+	// directories removed
+	os.Remove(path.Join(dir, entry))
+	New(WithDir(dir))
+	if b, _ := ioutil.ReadFile(path.Join(dir, entry)); string(b) != files["3c/"+entry] {
+		t.Errorf("unexpected migration to a shallower layout: got %q", b)
+	}
+	if _, err := os.Stat(path.Join(dir, "3c")); !os.IsNotExist(err) {
+		t.Errorf("expected emptied directory to be removed: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(path.Join(dir, "zz", entry)); string(b) != "misplaced entry" {
+		t.Errorf("unexpected migration of a misplaced entry: got %q", b)
+	}
+	if b, _ := ioutil.ReadFile(path.Join(dir, depthFile)); string(b) != "0\n" {
+		t.Errorf("unexpected recorded depth: got %q, want %q", b, "0\n")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	c := New(WithDir(dir), WithJanitor(time.Hour, time.Minute))
+	defer c.Close()
+
+	c.Set("expired", response(time.Now().Add(-2*time.Minute), 0))
+	c.Set("graced", response(time.Now().Add(-30*time.Second), 0))
+	c.Set("fresh", response(time.Now(), time.Hour))
+	c.Set("unknown", []byte("garbage"))
+
+	s, err := os.Stat(c.fullPath("expired"))
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	c.Sweep()
+
+	if _, ok := c.Get("expired"); ok {
+		t.Errorf("unexpected key %q in cache", "expired")
+	}
+
+	for _, key := range []string{"graced", "fresh", "unknown"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("expected key %q to be found in cache", key)
+		}
+	}
+
+	if got, want := c.Reclaimed(), s.Size(); got != want {
+		t.Errorf("unexpected reclaimed bytes: got %d, want %d", got, want)
+	}
+}
+
 func BenchmarkCache(b *testing.B) {
 	dir, err := ioutil.TempDir("", "diskcache")
 	if err != nil {
@@ -42,3 +349,60 @@ func BenchmarkCache(b *testing.B) {
 		}
 	})
 }
+
+func response(date time.Time, maxAge time.Duration) []byte {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=" + strconv.Itoa(int(maxAge.Seconds()))},
+		},
+	}
+	b, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func blobs(t *testing.T, dir string) int {
+	return len(new(Cache).files(path.Join(dir, blobsDir), ".blob"))
+}
+
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("Files", func(t *testing.T) {
+		cachetest.RunConformance(t, func() httpcache.Cache { return New(WithDir(dir)) })
+	})
+	t.Run("Dedup", func(t *testing.T) {
+		cachetest.RunConformance(t, func() httpcache.Cache { return New(WithDir(dir), WithDedup()) })
+	})
+}
+
+func TestRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	c := New(WithDir(dir))
+	c.Set("key2", []byte("value22"))
+	c.Set("key1", []byte("value1"))
+	c.Set("key3", []byte("value333"))
+
+	keys := []string{}
+	c.Range(func(key string, size int64) {
+		keys = append(keys, key+":"+strconv.FormatInt(size, 10))
+	})
+
+	if got, want := strings.Join(keys, ","), "key2:7,key1:6,key3:8"; got != want {
+		t.Errorf("unexpected entries: got %s, want %s", got, want)
+	}
+}
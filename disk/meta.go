@@ -0,0 +1,128 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	metaMagic   = "getcached/"
+	metaVersion = 1
+)
+
+var (
+	// ErrBadMeta is returned when an entry header can't be parsed.
+	ErrBadMeta = errors.New("bad entry header")
+
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Meta describes a cache entry. It is stored in a small
+// header at the beginning of the entry file.
+type Meta struct {
+	Version  int       // header format version
+	Key      string    // key of the entry
+	StoredAt time.Time // when the entry was stored
+	Size     int64     // size of the value
+	Checksum uint32    // CRC-32C of the value
+	Ref      string    // blob holding the body of the value, if deduplicated
+}
+
+func newMeta(key string, value []byte) Meta {
+	return Meta{
+		Version:  metaVersion,
+		Key:      key,
+		StoredAt: time.Now(),
+		Size:     int64(len(value)),
+		Checksum: crc32.Checksum(value, castagnoli),
+	}
+}
+
+// Verify tells if value matches the size and checksum of m.
+func (m Meta) Verify(value []byte) bool {
+	return int64(len(value)) == m.Size && crc32.Checksum(value, castagnoli) == m.Checksum
+}
+
+func (m Meta) bytes() []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s%d\n", metaMagic, m.Version)
+	fmt.Fprintf(buf, "key: %s\n", strconv.Quote(m.Key))
+	fmt.Fprintf(buf, "stored-at: %s\n", m.StoredAt.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(buf, "size: %d\n", m.Size)
+	fmt.Fprintf(buf, "checksum: %08x\n", m.Checksum)
+	if m.Ref != "" {
+		fmt.Fprintf(buf, "ref: %s\n", m.Ref)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// readMeta reads an entry header. It returns false, without
+// consuming r, if the entry has no header.
+func readMeta(r *bufio.Reader) (m Meta, ok bool, err error) {
+	if magic, _ := r.Peek(len(metaMagic)); string(magic) != metaMagic {
+		return m, false, nil
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return m, true, ErrBadMeta
+	}
+	if m.Version, err = strconv.Atoi(strings.TrimSpace(line[len(metaMagic):])); err != nil || m.Version != metaVersion {
+		return m, true, ErrBadMeta
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return m, true, ErrBadMeta
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			return m, true, ErrBadMeta
+		}
+
+		name, value := line[:i], line[i+2:]
+		switch name {
+		case "key":
+			m.Key, err = strconv.Unquote(value)
+		case "stored-at":
+			m.StoredAt, err = time.Parse(time.RFC3339Nano, value)
+		case "size":
+			m.Size, err = strconv.ParseInt(value, 10, 64)
+		case "checksum":
+			var sum uint64
+			sum, err = strconv.ParseUint(value, 16, 32)
+			m.Checksum = uint32(sum)
+		case "ref":
+			m.Ref = value
+		}
+		if err != nil {
+			return m, true, ErrBadMeta
+		}
+	}
+
+	return m, true, nil
+}
+
+// parseMeta splits an entry file into its header and payload.
+func parseMeta(b []byte) (m Meta, payload []byte, ok bool, err error) {
+	br := bytes.NewReader(b)
+	r := bufio.NewReader(br)
+	m, ok, err = readMeta(r)
+	if !ok || err != nil {
+		return m, b, ok, err
+	}
+	return m, b[len(b)-br.Len()-r.Buffered():], true, nil
+}
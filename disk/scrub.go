@@ -0,0 +1,98 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// scrubPause is the pause between two scrubs of the whole cache.
+const scrubPause = time.Minute
+
+var (
+	// ErrCorrupted is returned when an entry does
+	// not match its header.
+	ErrCorrupted = errors.New("corrupted entry")
+
+	errCollision = errors.New("key collision")
+)
+
+// Scrub verifies every entry of the cache, quarantining the
+// corrupted ones. It pauses between entries as configured by
+// WithScrubber and returns early when the cache is closed.
+func (c *Cache) Scrub() {
+	for _, fullpath := range c.files(c.dir, ".cache") {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.scrub):
+		}
+
+		l := c.getLock(fullpath)
+		l.RLock()
+		_, err := c.read(fullpath, "")
+		l.RUnlock()
+		c.releaseLock(l)
+
+		if err == ErrCorrupted {
+			c.quarantine(fullpath, "")
+		}
+	}
+}
+
+// Corrupted returns the number of corrupted entries quarantined.
+func (c *Cache) Corrupted() int64 {
+	return atomic.LoadInt64(&c.corrupted)
+}
+
+// quarantine moves a corrupted entry out of the way, in
+// the quarantine subdirectory, for later inspection.
+func (c *Cache) quarantine(fullpath, key string) {
+	l := c.getLock(fullpath)
+	defer c.releaseLock(l)
+
+	l.Lock()
+	defer l.Unlock()
+
+	if _, err := c.read(fullpath, key); err != ErrCorrupted {
+		return // replaced in the meantime
+	}
+
+	sum, _ := c.readRef(fullpath)
+	dir := path.Join(c.dir, quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	if err := os.Rename(fullpath, path.Join(dir, path.Base(fullpath))); err != nil {
+		return
+	}
+
+	atomic.AddInt64(&c.corrupted, 1)
+	if sum != "" && c.dedup {
+		c.release(sum)
+	}
+}
+
+func (c *Cache) scrubber() {
+	for {
+		c.Scrub()
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(scrubPause):
+		}
+	}
+}
+
+// WithScrubber configures a Cache to continuously verify its
+// entries in the background, pausing between each of them to
+// limit the load on the disk.
+func WithScrubber(pause time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.scrubbing = true
+		c.scrub = pause
+	}
+}
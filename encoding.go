@@ -0,0 +1,229 @@
+package getcached
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/compressed"
+	"github.com/mikegleasonjr/getcached/freshness"
+)
+
+// encoder is an http.RoundTripper negotiating the content
+// encoding of responses with clients. Responses are fetched
+// and cached in a single representation and transcoded to
+// the encoding preferred by the client. Transcoded variants
+// are cached as well so they are only computed once.
+type encoder struct {
+	tr     *httpcache.Transport
+	next   http.RoundTripper
+	codecs map[string]compressed.Codec // by content coding
+	prefs  []string                    // content codings by order of preference
+}
+
+func (e *encoder) add(coding string, codec compressed.Codec) {
+	if _, exists := e.codecs[coding]; !exists {
+		e.prefs = append(e.prefs, coding)
+	}
+	e.codecs[coding] = codec
+}
+
+// RoundTrip implements http.RoundTripper.
+func (e *encoder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return e.next.RoundTrip(req)
+	}
+
+	want := e.negotiate(req.Header.Get("Accept-Encoding"))
+
+	out := clone(req) // per RoundTripper contract
+	out.Header.Del("Accept-Encoding")
+
+	res, err := e.next.RoundTrip(out)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+
+	have := res.Header.Get("Content-Encoding")
+	if !e.negotiable(res.Header, have) {
+		return res, nil
+	}
+
+	addVary(res.Header, "Accept-Encoding")
+	if have == want {
+		return res, nil
+	}
+
+	key := variantKey(out.URL.String(), url.Values{"content-encoding": {want}})
+	if variant, ok := e.cached(key, res); ok {
+		res.Body.Close()
+		return variant, nil
+	}
+
+	return e.transcode(key, res, have, want)
+}
+
+// negotiable tells if a response can be transcoded.
+func (e *encoder) negotiable(h http.Header, coding string) bool {
+	if coding == "" {
+		return compressed.Compressible(h.Get("Content-Type"))
+	}
+	_, ok := e.codecs[coding]
+	return ok
+}
+
+// negotiate returns the preferred content coding accepted
+// by a client, or an empty string for the identity coding.
+func (e *encoder) negotiate(accept string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		accepted[coding] = true
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				accepted[coding] = err == nil && q > 0
+			}
+		}
+	}
+
+	for _, coding := range e.prefs {
+		if ok, exists := accepted[coding]; exists {
+			if ok {
+				return coding
+			}
+			continue
+		}
+		if accepted["*"] {
+			return coding
+		}
+	}
+
+	return ""
+}
+
+// cached returns the variant of a response stored as key, if
+// it was transcoded from the same representation.
+func (e *encoder) cached(key string, res *http.Response) (*http.Response, bool) {
+	b, ok := e.tr.Cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	variant, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), res.Request)
+	if err != nil {
+		return nil, false
+	}
+
+	v := validator(res.Header)
+	if v == "" || v != validator(variant.Header) {
+		return nil, false
+	}
+
+	return withBody(res, variant.Body, variant.ContentLength, variant.Header.Get("Content-Encoding")), true
+}
+
+// transcode re-encodes a response body and caches the
+// resulting variant if the response is cacheable.
+func (e *encoder) transcode(key string, res *http.Response, have, want string) (*http.Response, error) {
+	var body io.Reader = res.Body
+	defer res.Body.Close()
+
+	if have != "" {
+		r, err := e.codecs[have].NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		body = r
+	}
+
+	buf := new(bytes.Buffer)
+	var w io.WriteCloser = nopWriteCloser{buf}
+	if want != "" {
+		w = e.codecs[want].NewWriter(buf)
+	}
+
+	if _, err := io.Copy(w, body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	variant := withBody(res, ioutil.NopCloser(bytes.NewReader(buf.Bytes())), int64(buf.Len()), want)
+
+	if f, ok := freshness.FromHeader(res.Header); ok && f.Fresh > 0 && validator(res.Header) != "" {
+		stored := *variant
+		stored.Header = cloneHeader(variant.Header)
+		stored.Header.Del(httpcache.XFromCache)
+		stored.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
+		if b, err := httputil.DumpResponse(&stored, true); err == nil {
+			e.tr.Cache.Set(key, b)
+		}
+	}
+
+	return variant, nil
+}
+
+// withBody returns a copy of res with a body in another content coding.
+func withBody(res *http.Response, body io.ReadCloser, length int64, coding string) *http.Response {
+	cpy := new(http.Response)
+	*cpy = *res
+	cpy.Header = cloneHeader(res.Header)
+	cpy.Body = body
+	cpy.ContentLength = length
+	cpy.Uncompressed = false
+	cpy.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	if coding == "" {
+		cpy.Header.Del("Content-Encoding")
+	} else {
+		cpy.Header.Set("Content-Encoding", coding)
+	}
+	return cpy
+}
+
+// validator identifies the representation of a response.
+func validator(h http.Header) string {
+	for _, name := range []string{"Etag", "Last-Modified", "Date"} {
+		if v := h.Get(name); v != "" {
+			return name + ": " + v
+		}
+	}
+	return ""
+}
+
+func addVary(h http.Header, name string) {
+	for _, v := range h["Vary"] {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, s := range h {
+		h2[k] = append([]string(nil), s...)
+	}
+	return h2
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
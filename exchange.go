@@ -0,0 +1,115 @@
+package getcached
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mikegleasonjr/getcached/trace"
+)
+
+// CacheStatus tells how a Proxy served a request.
+type CacheStatus string
+
+// Cache statuses of the requests served by a Proxy.
+const (
+	StatusHit         CacheStatus = "HIT"         // served from the cache
+	StatusMiss        CacheStatus = "MISS"        // fetched from the origin
+	StatusRevalidated CacheStatus = "REVALIDATED" // served from the cache once validated by the origin
+	StatusStale       CacheStatus = "STALE"       // served from the cache as the origin failed
+)
+
+type exchangeKey struct{}
+
+// Exchange records how a Proxy served a request. Its fields
+// are set once the Proxy has served the request.
+type Exchange struct {
+	Origin        *url.URL      // origin requested, nil if invalid
+	FromCache     bool          // response served from the cache
+	Tier          string        // tier holding the cached response, if known
+	Fetches       int           // requests made to the origin
+	OriginStatus  int           // status of the last origin response, 0 if none
+	OriginErr     error         // error of the last origin request
+	OriginLatency time.Duration // time to the origin response headers
+}
+
+// CacheStatus returns the cache status of the exchange.
+func (e *Exchange) CacheStatus() CacheStatus {
+	switch {
+	case !e.FromCache:
+		return StatusMiss
+	case e.Fetches == 0:
+		return StatusHit
+	case e.OriginStatus == http.StatusNotModified:
+		return StatusRevalidated
+	default:
+		return StatusStale
+	}
+}
+
+// WithExchange returns a shallow copy of req whose context
+// holds an Exchange, along with the Exchange. Requests
+// already holding an Exchange are returned as is, so that
+// handlers wrapping a Proxy can share it.
+func WithExchange(req *http.Request) (*http.Request, *Exchange) {
+	if e := ExchangeFrom(req.Context()); e != nil {
+		return req, e
+	}
+	e := new(Exchange)
+	return req.WithContext(context.WithValue(req.Context(), exchangeKey{}, e)), e
+}
+
+// ExchangeFrom returns the Exchange held by ctx, if any.
+func ExchangeFrom(ctx context.Context) *Exchange {
+	e, _ := ctx.Value(exchangeKey{}).(*Exchange)
+	return e
+}
+
+// fetcher is the http.RoundTripper used by httpcache to
+// request origins, recording the fetches of an Exchange and
+// tracing them.
+type fetcher struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (f *fetcher) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := f.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	ctx, span := trace.StartChild(req.Context(), "origin", trace.Client)
+	if span != nil {
+		req = req.WithContext(ctx)
+		req.Header = cloneHeader(req.Header) // per RoundTripper contract
+		trace.Inject(ctx, req.Header)
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("url.full", req.URL.String())
+	}
+
+	start := time.Now()
+	res, err := next.RoundTrip(req)
+	finish(span, res, err)
+
+	if e := ExchangeFrom(req.Context()); e != nil {
+		e.Fetches++
+		e.OriginLatency += time.Since(start)
+		e.OriginErr = err
+		e.OriginStatus = 0
+		if err == nil {
+			e.OriginStatus = res.StatusCode
+		}
+	}
+
+	return res, err
+}
+
+// finish ends the span of a request to another service.
+func finish(span *trace.Span, res *http.Response, err error) {
+	if err == nil {
+		span.SetAttribute("http.status_code", res.StatusCode)
+	}
+	span.Finish(err)
+}
@@ -0,0 +1,71 @@
+package getcached
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestExchangeCacheStatus(t *testing.T) {
+	status := http.StatusOK
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=3600")
+		if req.URL.Path != "/fresh" {
+			rw.Header().Set("Cache-Control", "max-age=0, stale-if-error=3600")
+		}
+		rw.Header().Set("Etag", `"v1"`)
+		if req.Header.Get("If-None-Match") == `"v1"` && status == http.StatusOK {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		rw.WriteHeader(status)
+		rw.Write([]byte("content"))
+	}))
+	defer origin.Close()
+
+	p := New()
+	serve := func(path string) *Exchange {
+		req := httptest.NewRequest("GET", "/?q="+url.QueryEscape(origin.URL+path), nil)
+		req, e := WithExchange(req)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+		return e
+	}
+
+	tests := []struct {
+		path    string
+		status  int
+		want    CacheStatus
+		fetches int
+	}{
+		{"/fresh", http.StatusOK, StatusMiss, 1},
+		{"/fresh", http.StatusOK, StatusHit, 0},
+		{"/stale", http.StatusOK, StatusMiss, 1},
+		{"/stale", http.StatusOK, StatusRevalidated, 1},
+		{"/stale", http.StatusInternalServerError, StatusStale, 1},
+	}
+
+	for _, test := range tests {
+		status = test.status
+		e := serve(test.path)
+		if got := e.CacheStatus(); got != test.want {
+			t.Errorf("unexpected cache status for %s: got %s, want %s", test.path, got, test.want)
+		}
+		if e.Fetches != test.fetches {
+			t.Errorf("unexpected fetches for %s: got %d, want %d", test.path, e.Fetches, test.fetches)
+		}
+		if e.Origin == nil || e.Origin.Path != test.path {
+			t.Errorf("unexpected origin: got %v, want %s", e.Origin, test.path)
+		}
+	}
+}
+
+func TestWithExchange(t *testing.T) {
+	req, e := WithExchange(httptest.NewRequest("GET", "/", nil))
+	if got := ExchangeFrom(req.Context()); got != e {
+		t.Errorf("unexpected exchange: got %p, want %p", got, e)
+	}
+	if _, got := WithExchange(req); got != e {
+		t.Errorf("unexpected new exchange: got %p, want %p", got, e)
+	}
+}
@@ -0,0 +1,120 @@
+package freshness
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Freshness describes the lifetime of a stored response
+// as advertised by its Date, Age, Expires and Cache-Control
+// headers.
+type Freshness struct {
+	Date  time.Time     // when the response was generated
+	Fresh time.Duration // freshness lifetime, less the age of the response at Date
+	Stale time.Duration // window during which a stale response can still be served
+}
+
+// Expires returns the time at which the response becomes stale.
+func (f Freshness) Expires() time.Time {
+	return f.Date.Add(f.Fresh)
+}
+
+// StaleUntil returns the time after which the response
+// cannot be served at all, even stale.
+func (f Freshness) StaleUntil() time.Time {
+	return f.Expires().Add(f.Stale)
+}
+
+// Parse reads the freshness of a serialized response as stored
+// by httpcache. It returns false when the response carries no
+// explicit freshness information.
+func Parse(resp []byte) (Freshness, bool) {
+	return Read(bytes.NewReader(resp))
+}
+
+// Read is like Parse but reads the serialized response from r.
+// Only the response headers are consumed.
+func Read(r io.Reader) (f Freshness, ok bool) {
+	res, err := http.ReadResponse(bufio.NewReader(r), nil)
+	if err != nil {
+		return
+	}
+	return FromHeader(res.Header)
+}
+
+// FromHeader computes the freshness of a response from its headers.
+// An invalid Expires header stands for a time in the past, as per
+// RFC 9111 section 5.3.
+func FromHeader(h http.Header) (f Freshness, ok bool) {
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		return
+	}
+	f.Date = date
+
+	cc := parseCacheControl(h)
+	if _, noStore := cc["no-store"]; noStore {
+		return f, true
+	}
+
+	if maxAge, exists := cc["max-age"]; exists {
+		if f.Fresh, ok = seconds(maxAge); !ok {
+			return
+		}
+	} else if v, exists := h["Expires"]; exists {
+		if expires, err := http.ParseTime(v[0]); err == nil {
+			f.Fresh = expires.Sub(date)
+		}
+	} else {
+		return
+	}
+
+	if age, valid := seconds(h.Get("Age")); valid {
+		f.Fresh -= age
+	}
+
+	for _, directive := range []string{"stale-while-revalidate", "stale-if-error"} {
+		v, exists := cc[directive]
+		if !exists {
+			continue
+		}
+		stale, valid := seconds(v)
+		if !valid {
+			return f, false // unbounded staleness
+		}
+		if stale > f.Stale {
+			f.Stale = stale
+		}
+	}
+
+	return f, true
+}
+
+func seconds(v string) (time.Duration, bool) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func parseCacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, part := range strings.Split(h.Get("Cache-Control"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if i := strings.Index(part, "="); i >= 0 {
+			cc[strings.ToLower(part[:i])] = strings.Trim(part[i+1:], `"`)
+		} else {
+			cc[strings.ToLower(part)] = ""
+		}
+	}
+	return cc
+}
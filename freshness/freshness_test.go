@@ -0,0 +1,104 @@
+package freshness
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	date := time.Date(2019, 9, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header     http.Header
+		ok         bool
+		expires    time.Time
+		staleUntil time.Time
+	}{
+		{http.Header{}, false, time.Time{}, time.Time{}},
+		{http.Header{"Date": {date.Format(http.TimeFormat)}}, false, time.Time{}, time.Time{}},
+		{http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=60"},
+		}, true, date.Add(time.Minute), date.Add(time.Minute)},
+		{http.Header{
+			"Date":    {date.Format(http.TimeFormat)},
+			"Expires": {date.Add(time.Hour).Format(http.TimeFormat)},
+		}, true, date.Add(time.Hour), date.Add(time.Hour)},
+		{http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Expires":       {date.Add(time.Hour).Format(http.TimeFormat)},
+			"Cache-Control": {"public, max-age=10"},
+		}, true, date.Add(10 * time.Second), date.Add(10 * time.Second)},
+		{http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=10, stale-while-revalidate=20, stale-if-error=30"},
+		}, true, date.Add(10 * time.Second), date.Add(40 * time.Second)},
+		{http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=10, stale-if-error"},
+		}, false, time.Time{}, time.Time{}},
+		{http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=abc"},
+		}, false, time.Time{}, time.Time{}},
+		{http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=60"},
+			"Age":           {"20"},
+		}, true, date.Add(40 * time.Second), date.Add(40 * time.Second)},
+		{http.Header{
+			"Date":    {date.Format(http.TimeFormat)},
+			"Expires": {date.Add(time.Hour).Format(http.TimeFormat)},
+			"Age":     {"600"},
+		}, true, date.Add(50 * time.Minute), date.Add(50 * time.Minute)},
+		{http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=60"},
+			"Age":           {"abc"},
+		}, true, date.Add(time.Minute), date.Add(time.Minute)},
+		{http.Header{
+			"Date":    {date.Format(http.TimeFormat)},
+			"Expires": {"0"},
+		}, true, date, date},
+		{http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Expires":       {"garbage"},
+			"Cache-Control": {"stale-while-revalidate=30"},
+		}, true, date, date.Add(30 * time.Second)},
+		{http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Expires":       {"0"},
+			"Cache-Control": {"max-age=10"},
+		}, true, date.Add(10 * time.Second), date.Add(10 * time.Second)},
+	}
+
+	for i, test := range tests {
+		res := &http.Response{StatusCode: http.StatusOK, ProtoMajor: 1, ProtoMinor: 1, Header: test.header}
+		b, err := httputil.DumpResponse(res, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %q", err)
+		}
+
+		f, ok := Parse(b)
+		if ok != test.ok {
+			t.Errorf("test %d: unexpected ok: got %t, want %t", i, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got, want := f.Expires(), test.expires; !got.Equal(want) {
+			t.Errorf("test %d: unexpected expiry: got %s, want %s", i, got, want)
+		}
+		if got, want := f.StaleUntil(), test.staleUntil; !got.Equal(want) {
+			t.Errorf("test %d: unexpected stale limit: got %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestParseGarbage(t *testing.T) {
+	if _, ok := Parse([]byte("garbage")); ok {
+		t.Errorf("expected garbage not to be parsed")
+	}
+}
@@ -1,9 +1,22 @@
 module github.com/mikegleasonjr/getcached
 
-go 1.13
+go 1.22
 
 require (
+	github.com/andybalholm/brotli v1.0.6
+	github.com/golang/snappy v1.0.0
 	github.com/google/go-cmp v0.3.1
 	github.com/gregjones/httpcache v0.0.0-20190611155906-901d90724c79
+	github.com/klauspost/compress v1.18.0
 	github.com/stretchr/testify v1.4.0
+	go.etcd.io/bbolt v1.3.5
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.1.0 // indirect
+	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5 // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
@@ -0,0 +1,116 @@
+package getcached
+
+import (
+	"sort"
+	"time"
+)
+
+var (
+	// DefaultLatencyBuckets are the upper bounds, in
+	// nanoseconds, of the latency histograms of a Monitor.
+	DefaultLatencyBuckets = []int64{
+		int64(50 * time.Microsecond),
+		int64(100 * time.Microsecond),
+		int64(250 * time.Microsecond),
+		int64(500 * time.Microsecond),
+		int64(time.Millisecond),
+		int64(2500 * time.Microsecond),
+		int64(5 * time.Millisecond),
+		int64(10 * time.Millisecond),
+		int64(25 * time.Millisecond),
+		int64(50 * time.Millisecond),
+		int64(100 * time.Millisecond),
+		int64(250 * time.Millisecond),
+		int64(500 * time.Millisecond),
+		int64(time.Second),
+		int64(2500 * time.Millisecond),
+	}
+
+	// DefaultSizeBuckets are the upper bounds, in bytes,
+	// of the size histogram of a Monitor.
+	DefaultSizeBuckets = []int64{
+		1 << 10,
+		4 << 10,
+		16 << 10,
+		64 << 10,
+		256 << 10,
+		1 << 20,
+		4 << 20,
+		16 << 20,
+		64 << 20,
+	}
+)
+
+// Histogram counts observations in buckets of increasing
+// upper bounds. It is safe for concurrent use.
+type Histogram struct {
+	bounds []int64
+	counts []AtomicInt // one more than bounds for the overflow
+	sum    AtomicInt
+}
+
+// NewHistogram creates a Histogram with buckets of the given
+// upper bounds, which are sorted if needed.
+func NewHistogram(bounds ...int64) *Histogram {
+	b := append([]int64(nil), bounds...)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	return &Histogram{
+		bounds: b,
+		counts: make([]AtomicInt, len(b)+1),
+	}
+}
+
+// Observe adds v to the bucket of the smallest upper bound
+// greater than or equal to it.
+func (h *Histogram) Observe(v int64) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return v <= h.bounds[i] })
+	h.counts[i].Add(1)
+	h.sum.Add(v)
+}
+
+// Distribution returns the observations made so far.
+func (h *Histogram) Distribution() Distribution {
+	d := Distribution{
+		Bounds: append([]int64(nil), h.bounds...),
+		Counts: make([]int64, len(h.counts)),
+		Sum:    h.sum.Get(),
+	}
+	for i := range h.counts {
+		d.Counts[i] = h.counts[i].Get()
+		d.Count += d.Counts[i]
+	}
+	return d
+}
+
+// Distribution is a snapshot of a Histogram.
+type Distribution struct {
+	Bounds []int64 // upper bounds of the buckets
+	Counts []int64 // observations per bucket, the last one counting those above every bound
+	Count  int64   // total observations
+	Sum    int64   // sum of the observations
+}
+
+// Quantile estimates the value below which falls the q
+// fraction of the observations, interpolating linearly
+// within buckets. Observations above the last bound are
+// reported as the last bound.
+func (d Distribution) Quantile(q float64) int64 {
+	if d.Count == 0 || len(d.Bounds) == 0 {
+		return 0
+	}
+
+	rank := q * float64(d.Count)
+	var cum, lower int64
+	for i, n := range d.Counts {
+		if i == len(d.Bounds) {
+			break
+		}
+		if n > 0 && float64(cum+n) >= rank {
+			upper := d.Bounds[i]
+			return lower + int64(float64(upper-lower)*(rank-float64(cum))/float64(n))
+		}
+		cum += n
+		lower = d.Bounds[i]
+	}
+	return d.Bounds[len(d.Bounds)-1]
+}
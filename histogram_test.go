@@ -0,0 +1,59 @@
+package getcached
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(100, 10, 1000)
+	for _, v := range []int64{1, 10, 11, 50, 100, 500, 5000} {
+		h.Observe(v)
+	}
+
+	want := Distribution{
+		Bounds: []int64{10, 100, 1000},
+		Counts: []int64{2, 3, 1, 1},
+		Count:  7,
+		Sum:    5672,
+	}
+	if diff := cmp.Diff(want, h.Distribution()); diff != "" {
+		t.Errorf("distribution mismatch (-want +got):\n%s", diff)
+	}
+
+	h.Distribution().Bounds[0] = 1000
+	h.Observe(20)
+	if got := h.Distribution(); got.Bounds[0] != 10 || got.Counts[1] != 4 {
+		t.Errorf("unexpected distribution after changing the bounds of a snapshot: %+v", got)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	d := Distribution{
+		Bounds: []int64{10, 100, 1000},
+		Counts: []int64{50, 40, 9, 1},
+		Count:  100,
+	}
+
+	tests := []struct {
+		q    float64
+		want int64
+	}{
+		{0.1, 2},
+		{0.5, 10},
+		{0.7, 55},
+		{0.99, 1000},
+		{1, 1000},
+	}
+
+	for _, test := range tests {
+		if got := d.Quantile(test.q); got != test.want {
+			t.Errorf("unexpected quantile %v: got %d, want %d", test.q, got, test.want)
+		}
+	}
+
+	if got := (Distribution{}).Quantile(0.5); got != 0 {
+		t.Errorf("unexpected quantile of an empty distribution: got %d, want %d", got, 0)
+	}
+}
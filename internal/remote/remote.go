@@ -0,0 +1,306 @@
+// Package remote provides the connection handling shared by
+// the network cache backends.
+package remote
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds the time spent getting a
+	// connection, including dialing it.
+	DefaultTimeout = 100 * time.Millisecond
+	// DefaultIOTimeout bounds the time spent waiting for the
+	// server to accept or send the next bytes of a call.
+	DefaultIOTimeout = 100 * time.Millisecond
+	// DefaultPoolSize is the max number of connections.
+	DefaultPoolSize = 16
+	// DefaultThreshold is the number of consecutive failures
+	// opening the circuit.
+	DefaultThreshold = 5
+	// DefaultCooldown is the time the circuit stays open.
+	DefaultCooldown = 5 * time.Second
+)
+
+var (
+	// ErrOpen is returned when the circuit is open.
+	ErrOpen = errors.New("circuit open")
+	// ErrBusy is returned when no connection freed up in time.
+	ErrBusy = errors.New("no connection available")
+	// ErrClosed is returned when the pool is closed.
+	ErrClosed = errors.New("pool closed")
+)
+
+// ReplyError is an error replied by the server. It does
+// not count as a failure of the server.
+type ReplyError string
+
+func (e ReplyError) Error() string { return string(e) }
+
+// Options configures the connections to a server, shared
+// by the backends to configure themselves.
+type Options struct {
+	Addr      string        // TCP address of the server
+	Prefix    string        // added to the keys
+	Timeout   time.Duration // max time getting a connection
+	IOTimeout time.Duration // max time without the server making progress
+	PoolSize  int           // max number of connections
+	Threshold int           // consecutive failures opening the circuit, 0 to disable
+	Cooldown  time.Duration // time the circuit stays open
+}
+
+// NewOptions returns the default Options of a server.
+func NewOptions(addr string) Options {
+	return Options{
+		Addr:      addr,
+		Timeout:   DefaultTimeout,
+		IOTimeout: DefaultIOTimeout,
+		PoolSize:  DefaultPoolSize,
+		Threshold: DefaultThreshold,
+		Cooldown:  DefaultCooldown,
+	}
+}
+
+// Conn is a buffered connection to a server.
+type Conn struct {
+	net.Conn
+	R *bufio.Reader
+	W *bufio.Writer
+}
+
+// progressConn fails reads and writes once the server made
+// no progress for a timeout, leaving calls transferring large
+// values the time they need.
+type progressConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// maxWrite is the size of the writes given a timeout.
+const maxWrite = 64 << 10
+
+func (c progressConn) Read(b []byte) (int, error) {
+	if err := c.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c progressConn) Write(b []byte) (n int, err error) {
+	for n < len(b) {
+		end := n + maxWrite
+		if end > len(b) {
+			end = len(b)
+		}
+		if err = c.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+			return n, err
+		}
+		var w int
+		w, err = c.Conn.Write(b[n:end])
+		n += w
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Pool is a bounded pool of connections to a server. It stops
+// using the server for a while after consecutive failures.
+type Pool struct {
+	addr      string
+	timeout   time.Duration
+	ioTimeout time.Duration
+	slots     chan struct{} // held by open connections
+	idle      chan *Conn
+	breaker   *Breaker
+	mu        sync.Mutex // guards closed
+	closed    bool
+}
+
+// NewPool creates a Pool of connections configured by o.
+func NewPool(o Options) *Pool {
+	return &Pool{
+		addr:      o.Addr,
+		timeout:   o.Timeout,
+		ioTimeout: o.IOTimeout,
+		slots:     make(chan struct{}, o.PoolSize),
+		idle:      make(chan *Conn, o.PoolSize),
+		breaker:   NewBreaker(o.Threshold, o.Cooldown),
+	}
+}
+
+// Do calls fn with a connection. The connection is discarded
+// if fn fails with anything but a ReplyError. Waiting for a
+// connection of a busy pool or a closed one does not count as
+// a failure of the server.
+func (p *Pool) Do(fn func(*Conn) error) error {
+	if !p.breaker.Allow() {
+		return ErrOpen
+	}
+
+	c, err := p.get(time.Now().Add(p.timeout))
+	if err == ErrBusy || err == ErrClosed {
+		p.breaker.Skip()
+		return err
+	}
+	if err != nil {
+		p.breaker.Failure()
+		return err
+	}
+
+	err = fn(c)
+
+	var reply ReplyError
+	if err != nil && !errors.As(err, &reply) {
+		p.breaker.Failure()
+		p.discard(c)
+		return err
+	}
+
+	p.breaker.Success()
+	p.put(c)
+	return err
+}
+
+// Close closes the idle connections. Connections in use are
+// closed once released.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	for {
+		select {
+		case c := <-p.idle:
+			p.discard(c)
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *Pool) get(deadline time.Time) (*Conn, error) {
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case c := <-p.idle:
+		return c, nil
+	case p.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, ErrBusy
+	}
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		<-p.slots
+		return nil, ErrClosed
+	}
+
+	nc, err := net.DialTimeout("tcp", p.addr, time.Until(deadline))
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	pc := progressConn{Conn: nc, timeout: p.ioTimeout}
+	return &Conn{Conn: nc, R: bufio.NewReader(pc), W: bufio.NewWriter(pc)}, nil
+}
+
+func (p *Pool) put(c *Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		p.discard(c)
+		return
+	}
+	p.idle <- c // never blocks, there are as many slots
+}
+
+func (p *Pool) discard(c *Conn) {
+	c.Close()
+	<-p.slots
+}
+
+// Breaker is a circuit breaker. It opens after a number of
+// consecutive failures and lets a single call through once
+// it has been open for a cooldown period.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex // guards the fields below
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewBreaker creates a Breaker.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow tells if a call can be made.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Success records a successful call, closing the circuit.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure records a failed call.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// Skip records a call given up before reaching the server.
+func (b *Breaker) Skip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// Open tells if the circuit is open.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.threshold > 0 && b.failures >= b.threshold && time.Now().Before(b.openUntil)
+}
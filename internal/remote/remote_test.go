@@ -0,0 +1,180 @@
+package remote
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(2, 20*time.Millisecond)
+
+	b.Failure()
+	if !b.Allow() || b.Open() {
+		t.Errorf("unexpected open circuit after 1 failure")
+	}
+	b.Failure()
+	if b.Allow() || !b.Open() {
+		t.Errorf("unexpected closed circuit after 2 failures")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Errorf("unexpected open circuit after cooldown")
+	}
+	if b.Allow() {
+		t.Errorf("unexpected call allowed while probing")
+	}
+	b.Skip()
+	if !b.Allow() {
+		t.Errorf("unexpected open circuit after a skipped probe")
+	}
+
+	b.Success()
+	if !b.Allow() || b.Open() {
+		t.Errorf("unexpected open circuit after success")
+	}
+}
+
+func TestPool(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer l.Close()
+
+	accepted := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			defer conn.Close()
+		}
+	}()
+
+	o := NewOptions(l.Addr().String())
+	o.Timeout, o.PoolSize, o.Threshold = 50*time.Millisecond, 1, 0
+	p := NewPool(o)
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := p.Do(func(*Conn) error { return nil }); err != nil {
+			t.Errorf("unexpected error: %q", err)
+		}
+	}
+	if err := p.Do(func(*Conn) error { return ReplyError("ERR") }); err == nil {
+		t.Errorf("unexpected success")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got, want := len(accepted), 1; got != want {
+		t.Errorf("unexpected connections: got %d, want %d", got, want)
+	}
+
+	released := make(chan struct{})
+	go p.Do(func(*Conn) error {
+		<-released
+		return nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	if err := p.Do(func(*Conn) error { return nil }); err != ErrBusy {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrBusy)
+	}
+	close(released)
+
+	if err := p.Do(func(*Conn) error { return errors.New("broken") }); err == nil {
+		t.Errorf("unexpected success")
+	}
+	if err := p.Do(func(*Conn) error { return nil }); err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got, want := len(accepted), 2; got != want {
+		t.Errorf("unexpected connections: got %d, want %d", got, want)
+	}
+}
+
+func TestPoolBusy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	o := NewOptions(l.Addr().String())
+	o.Timeout, o.PoolSize, o.Threshold = 10*time.Millisecond, 1, 1
+	p := NewPool(o)
+	defer p.Close()
+
+	released := make(chan struct{})
+	go p.Do(func(*Conn) error {
+		<-released
+		return nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := p.Do(func(*Conn) error { return nil }); err != ErrBusy {
+			t.Errorf("unexpected error: got %v, want %v", err, ErrBusy)
+		}
+	}
+	close(released)
+
+	if err := p.Do(func(*Conn) error { return nil }); err != nil {
+		t.Errorf("unexpected error of a busy pool: got %v, want nil", err)
+	}
+}
+
+func TestPoolProgress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer l.Close()
+
+	// sends 10 chunks in 200ms, then stalls
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < 10; i++ {
+			time.Sleep(20 * time.Millisecond)
+			conn.Write(make([]byte, 1000))
+		}
+		time.Sleep(time.Second)
+	}()
+
+	o := NewOptions(l.Addr().String())
+	o.IOTimeout = 50 * time.Millisecond
+	p := NewPool(o)
+	defer p.Close()
+
+	err = p.Do(func(c *Conn) error {
+		if _, err := io.ReadFull(c.R, make([]byte, 10000)); err != nil {
+			return err
+		}
+		start := time.Now()
+		_, err := c.R.ReadByte()
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("unexpected read duration of a stalled server: got %v", elapsed)
+		}
+		return err
+	})
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Errorf("unexpected error: got %v, want a timeout", err)
+	}
+}
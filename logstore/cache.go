@@ -0,0 +1,541 @@
+package logstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultDir          = "/tmp"
+	defaultSegmentSize  = 64 << 20 // 64MB
+	defaultGarbageRatio = 0.5
+	headerSize          = 13 // crc, key length, value length, op
+	opSet               = 0
+	opDelete            = 1
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Cache caches requests in large append-only segment files
+// indexed in memory. Overwritten and deleted values are
+// reclaimed by compacting segments holding mostly garbage.
+type Cache struct {
+	dir          string
+	segmentSize  int64
+	garbageRatio float64
+	mu           sync.RWMutex // guards index and segments
+	compacting   sync.Mutex   // serializes compactions
+	index        map[string]location
+	segments     map[int]*segment
+	active       *segment
+	compact      chan struct{}
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+type segment struct {
+	id    int
+	f     *os.File
+	size  int64 // bytes written
+	live  int64 // bytes of indexed records
+	tombs int64 // bytes of tombstones
+}
+
+type location struct {
+	seg  *segment
+	off  int64
+	klen uint32
+	vlen uint32
+}
+
+func (l location) size() int64 {
+	return headerSize + int64(l.klen) + int64(l.vlen)
+}
+
+// New creates a Cache backed by a directory, recovering the
+// values found in existing segments. Panics if the directory
+// does not exist or segments can't be read.
+func New(options ...func(*Cache)) *Cache {
+	c := &Cache{
+		dir:          defaultDir,
+		segmentSize:  defaultSegmentSize,
+		garbageRatio: defaultGarbageRatio,
+		index:        map[string]location{},
+		segments:     map[int]*segment{},
+		compact:      make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if s, err := os.Stat(c.dir); os.IsNotExist(err) || !s.IsDir() {
+		panic(fmt.Sprintf("%q does not exists", c.dir))
+	}
+
+	if err := c.recover(); err != nil {
+		panic(err)
+	}
+
+	go c.compactor()
+
+	return c
+}
+
+// Get gets an item from the cache.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	loc, ok := c.index[key]
+	if !ok {
+		return nil, false
+	}
+
+	rec := make([]byte, loc.size())
+	if _, err := loc.seg.f.ReadAt(rec, loc.off); err != nil {
+		return nil, false
+	}
+
+	op, k, v, ok := decode(rec)
+	if !ok || op != opSet || k != key {
+		return nil, false
+	}
+
+	return v, true
+}
+
+// Set saves a response to the cache as key.
+func (c *Cache) Set(key string, resp []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loc, err := c.append(encode(opSet, key, resp))
+	if err != nil {
+		return
+	}
+
+	c.unindex(key)
+	c.index[key] = loc
+	loc.seg.live += loc.size()
+	c.maybeCompact()
+}
+
+// Delete deletes an item from the cache.
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.index[key]; !ok {
+		return
+	}
+
+	loc, err := c.append(encode(opDelete, key, nil))
+	if err != nil {
+		return
+	}
+
+	c.unindex(key)
+	loc.seg.tombs += loc.size()
+	c.maybeCompact()
+}
+
+// Range calls fn with the key and size of every value of the
+// cache, from the least to the most recently stored.
+func (c *Cache) Range(fn func(key string, size int64)) {
+	type entry struct {
+		key string
+		loc location
+	}
+
+	c.mu.RLock()
+	entries := make([]entry, 0, len(c.index))
+	for key, loc := range c.index {
+		entries = append(entries, entry{key, loc})
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].loc, entries[j].loc
+		return a.seg.id < b.seg.id || (a.seg.id == b.seg.id && a.off < b.off)
+	})
+
+	for _, e := range entries {
+		fn(e.key, int64(e.loc.vlen))
+	}
+}
+
+// Compact rewrites the segments holding mostly garbage with
+// only their live records, removing the segments left empty.
+// The cache remains usable while segments are copied.
+func (c *Cache) Compact() {
+	c.compacting.Lock()
+	defer c.compacting.Unlock()
+
+	c.mu.RLock()
+	segments := c.sorted()
+	c.mu.RUnlock()
+
+	for _, seg := range segments {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		c.mu.RLock()
+		oldest := c.oldest() == seg
+		compactable := c.compactable(seg, oldest)
+		c.mu.RUnlock()
+
+		if compactable {
+			c.compactSegment(seg, oldest)
+		}
+	}
+}
+
+// Close stops the compaction and closes the segments.
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+
+	c.compacting.Lock()
+	defer c.compacting.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for _, seg := range c.segments {
+		if e := seg.f.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (c *Cache) compactor() {
+	for {
+		select {
+		case <-c.compact:
+			c.Compact()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Cache) maybeCompact() {
+	oldest := c.oldest()
+	for _, seg := range c.segments {
+		if c.compactable(seg, seg == oldest) {
+			select {
+			case c.compact <- struct{}{}:
+			default:
+			}
+			return
+		}
+	}
+}
+
+// compactable reports whether a sealed segment holds mostly
+// garbage. Tombstones are garbage in the oldest segment only.
+func (c *Cache) compactable(seg *segment, oldest bool) bool {
+	kept := seg.live
+	if !oldest {
+		kept += seg.tombs
+	}
+	return seg != c.active && float64(kept) < float64(seg.size)*c.garbageRatio
+}
+
+// compactSegment rewrites a sealed segment with only its live
+// records, keeping its id so that replaying the log applies them
+// in the same order. Tombstones are kept unless the segment is the
+// oldest or their key was stored again, as older segments may hold
+// the values they delete. Records are copied without holding the
+// lock, only taken to swap the rewritten segment in.
+func (c *Cache) compactSegment(seg *segment, oldest bool) {
+	tmp := c.segmentPath(seg.id) + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+
+	compacted := &segment{id: seg.id, f: f}
+	moved := map[string]int64{} // offsets in the rewritten segment
+	err = scan(seg.f, func(off int64, op byte, key string, rec []byte) error {
+		c.mu.RLock()
+		loc, indexed := c.index[key]
+		c.mu.RUnlock()
+
+		switch {
+		case op == opSet && indexed && loc.seg == seg && loc.off == off:
+			moved[key] = compacted.size
+		case op == opDelete && !indexed && !oldest:
+			compacted.tombs += int64(len(rec))
+		default:
+			return nil
+		}
+
+		if _, err := f.WriteAt(rec, compacted.size); err != nil {
+			return err
+		}
+		compacted.size += int64(len(rec))
+		return nil
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil || compacted.size == 0 {
+		f.Close()
+		os.Remove(tmp)
+		if err == nil {
+			seg.f.Close()
+			os.Remove(c.segmentPath(seg.id))
+			delete(c.segments, seg.id)
+		}
+		return
+	}
+
+	if err := os.Rename(tmp, c.segmentPath(seg.id)); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return
+	}
+
+	// values overwritten or deleted while copying stay unindexed
+	for key, off := range moved {
+		if loc, ok := c.index[key]; ok && loc.seg == seg {
+			loc.seg, loc.off = compacted, off
+			c.index[key] = loc
+			compacted.live += loc.size()
+		}
+	}
+
+	seg.f.Close()
+	c.segments[seg.id] = compacted
+}
+
+// append appends a record to the active segment, rotating
+// it if it is full.
+func (c *Cache) append(rec []byte) (loc location, err error) {
+	if c.active.size > 0 && c.active.size+int64(len(rec)) > c.segmentSize {
+		if err := c.rotate(); err != nil {
+			return loc, err
+		}
+	}
+
+	seg := c.active
+	if _, err := seg.f.WriteAt(rec, seg.size); err != nil {
+		seg.f.Truncate(seg.size)
+		return loc, err
+	}
+
+	loc = location{
+		seg:  seg,
+		off:  seg.size,
+		klen: binary.BigEndian.Uint32(rec[4:8]),
+		vlen: binary.BigEndian.Uint32(rec[8:12]),
+	}
+	seg.size += int64(len(rec))
+
+	return loc, nil
+}
+
+func (c *Cache) unindex(key string) {
+	if old, ok := c.index[key]; ok {
+		old.seg.live -= old.size()
+		delete(c.index, key)
+	}
+}
+
+func (c *Cache) rotate() error {
+	id := 1
+	if c.active != nil {
+		id = c.active.id + 1
+	}
+
+	f, err := os.OpenFile(c.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	c.active = &segment{id: id, f: f}
+	c.segments[id] = c.active
+
+	return nil
+}
+
+// recover rebuilds the index by replaying the segments. Segments
+// are truncated after their last valid record, dropping the
+// records torn by a crash.
+func (c *Cache) recover() error {
+	// segments rewritten by an interrupted compaction are intact
+	torn, _ := filepath.Glob(path.Join(c.dir, "*.seg.compact"))
+	for _, match := range torn {
+		if err := os.Remove(match); err != nil {
+			return err
+		}
+	}
+
+	matches, _ := filepath.Glob(path.Join(c.dir, "*.seg"))
+
+	for _, match := range matches {
+		id, err := strconv.Atoi(strings.TrimSuffix(path.Base(match), ".seg"))
+		if err != nil {
+			continue
+		}
+
+		f, err := os.OpenFile(match, os.O_RDWR, 0644)
+		if err != nil {
+			return err
+		}
+		c.segments[id] = &segment{id: id, f: f}
+	}
+
+	for _, seg := range c.sorted() {
+		err := scan(seg.f, func(off int64, op byte, key string, rec []byte) error {
+			c.unindex(key)
+			if op == opSet {
+				loc := location{seg: seg, off: off, klen: uint32(len(key)), vlen: uint32(len(rec) - headerSize - len(key))}
+				c.index[key] = loc
+				seg.live += loc.size()
+			} else {
+				seg.tombs += int64(len(rec))
+			}
+			seg.size = off + int64(len(rec))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := seg.f.Truncate(seg.size); err != nil {
+			return err
+		}
+		c.active = seg
+	}
+
+	if c.active == nil {
+		return c.rotate()
+	}
+
+	return nil
+}
+
+func (c *Cache) sorted() []*segment {
+	segments := make([]*segment, 0, len(c.segments))
+	for _, seg := range c.segments {
+		segments = append(segments, seg)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].id < segments[j].id })
+	return segments
+}
+
+func (c *Cache) oldest() *segment {
+	var oldest *segment
+	for _, seg := range c.segments {
+		if oldest == nil || seg.id < oldest.id {
+			oldest = seg
+		}
+	}
+	return oldest
+}
+
+func (c *Cache) segmentPath(id int) string {
+	return path.Join(c.dir, fmt.Sprintf("%08d.seg", id))
+}
+
+// scan calls fn for every valid record of a segment, stopping
+// at the end of the segment or at the first invalid record.
+func scan(f *os.File, fn func(off int64, op byte, key string, rec []byte) error) error {
+	s, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var off int64
+	header := make([]byte, headerSize)
+
+	for {
+		if _, err := f.ReadAt(header, off); err != nil {
+			return nil
+		}
+
+		size := headerSize + int64(binary.BigEndian.Uint32(header[4:8])) + int64(binary.BigEndian.Uint32(header[8:12]))
+		if off+size > s.Size() {
+			return nil // torn
+		}
+
+		rec := make([]byte, size)
+		if _, err := f.ReadAt(rec, off); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		op, key, _, ok := decode(rec)
+		if !ok {
+			return nil
+		}
+
+		if err := fn(off, op, key, rec); err != nil {
+			return err
+		}
+		off += size
+	}
+}
+
+func encode(op byte, key string, value []byte) []byte {
+	rec := make([]byte, headerSize+len(key)+len(value))
+	binary.BigEndian.PutUint32(rec[4:8], uint32(len(key)))
+	binary.BigEndian.PutUint32(rec[8:12], uint32(len(value)))
+	rec[12] = op
+	copy(rec[headerSize:], key)
+	copy(rec[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(rec[0:4], crc32.Checksum(rec[4:], castagnoli))
+	return rec
+}
+
+func decode(rec []byte) (op byte, key string, value []byte, ok bool) {
+	if len(rec) < headerSize || binary.BigEndian.Uint32(rec[0:4]) != crc32.Checksum(rec[4:], castagnoli) {
+		return
+	}
+	klen := int(binary.BigEndian.Uint32(rec[4:8]))
+	if headerSize+klen > len(rec) {
+		return
+	}
+	return rec[12], string(rec[headerSize : headerSize+klen]), rec[headerSize+klen:], true
+}
+
+// WithDir sets a cache directory.
+func WithDir(dir string) func(*Cache) {
+	return func(c *Cache) {
+		c.dir = dir
+	}
+}
+
+// WithSegmentSize configures the size (in bytes) after which
+// segments are sealed and a new one is started.
+func WithSegmentSize(size int64) func(*Cache) {
+	return func(c *Cache) {
+		c.segmentSize = size
+	}
+}
+
+// WithGarbageRatio configures the ratio of live bytes under
+// which a sealed segment gets compacted.
+func WithGarbageRatio(ratio float64) func(*Cache) {
+	return func(c *Cache) {
+		c.garbageRatio = ratio
+	}
+}
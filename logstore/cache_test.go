@@ -0,0 +1,243 @@
+package logstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+	"github.com/gregjones/httpcache/test"
+	"github.com/mikegleasonjr/getcached/cachetest"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstore")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	c := New(WithDir(dir))
+	defer c.Close()
+	test.Cache(t, c)
+}
+
+func TestRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstore")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New(WithDir(dir), WithSegmentSize(64))
+	c.Set("key1", []byte("value1"))
+	c.Set("key2", []byte("value2"))
+	c.Set("key1", []byte("value3"))
+	c.Set("key3", []byte("value4"))
+	c.Delete("key2")
+	c.Close()
+
+	// simulate a write torn by a crash
+	last := segments(t, dir)
+	f, err := os.OpenFile(last[len(last)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	f.Write(encode(opSet, "key4", []byte("value5"))[:15])
+	f.Close()
+
+	c = New(WithDir(dir), WithSegmentSize(64))
+	defer c.Close()
+
+	want := map[string]string{"key1": "value3", "key3": "value4"}
+	for _, key := range []string{"key1", "key2", "key3", "key4"} {
+		got, ok := c.Get(key)
+		if ok != (want[key] != "") || string(got) != want[key] {
+			t.Errorf("unexpected value for %q: got %q (%t), want %q", key, got, ok, want[key])
+		}
+	}
+
+	keys := []string{}
+	c.Range(func(key string, size int64) {
+		keys = append(keys, key)
+	})
+	if got, want := len(keys), 2; got != want || keys[0] != "key1" || keys[1] != "key3" {
+		t.Errorf("unexpected keys: got %v", keys)
+	}
+
+	c.Set("key4", []byte("value6"))
+	if got, ok := c.Get("key4"); !ok || string(got) != "value6" {
+		t.Errorf("unexpected value for %q: got %q", "key4", got)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstore")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New(WithDir(dir), WithSegmentSize(1024))
+	value := bytes.Repeat([]byte("a"), 100)
+	for i := 0; i < 100; i++ {
+		c.Set("key"+strconv.Itoa(i), value)
+	}
+	before := len(segments(t, dir))
+	for i := 0; i < 90; i++ {
+		c.Delete("key" + strconv.Itoa(i))
+	}
+	c.Compact()
+	after := len(segments(t, dir))
+
+	if after >= before {
+		t.Errorf("expected segments to be compacted: got %d, had %d", after, before)
+	}
+
+	for i := 90; i < 100; i++ {
+		if got, ok := c.Get("key" + strconv.Itoa(i)); !ok || !bytes.Equal(got, value) {
+			t.Errorf("unexpected value for %q: got %q", "key"+strconv.Itoa(i), got)
+		}
+	}
+	c.Close()
+
+	c = New(WithDir(dir), WithSegmentSize(1024))
+	defer c.Close()
+	for i := 0; i < 100; i++ {
+		if _, ok := c.Get("key" + strconv.Itoa(i)); ok != (i >= 90) {
+			t.Errorf("unexpected presence of %q after recovery: %t", "key"+strconv.Itoa(i), ok)
+		}
+	}
+}
+
+func TestCompactTombstones(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstore")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// records are 22 bytes, tombstones 17 bytes
+	c := New(WithDir(dir), WithSegmentSize(64))
+	value := []byte("value")
+	c.Set("key0", value) // segment 1
+	c.Set("key1", value)
+	c.Set("key2", value) // segment 2
+	c.Delete("key1")
+	c.Delete("key2")
+	c.Set("key3", value) // segment 3
+
+	// segment 2 only holds tombstones for values of segment 1
+	for i := 0; i < 3; i++ {
+		c.Compact()
+	}
+	if got, want := sizes(t, dir), []int64{44, 56, 22}; !equal(got, want) {
+		t.Errorf("unexpected segment sizes: got %v, want %v", got, want)
+	}
+
+	// its tombstones are dropped once segment 1 is reclaimed
+	c.Delete("key0")
+	c.Compact()
+	if got, want := sizes(t, dir), []int64{39}; !equal(got, want) {
+		t.Errorf("unexpected segment sizes: got %v, want %v", got, want)
+	}
+	c.Close()
+
+	c = New(WithDir(dir), WithSegmentSize(64))
+	defer c.Close()
+	for _, key := range []string{"key0", "key1", "key2", "key3"} {
+		if _, ok := c.Get(key); ok != (key == "key3") {
+			t.Errorf("unexpected presence of %q after recovery: %t", key, ok)
+		}
+	}
+}
+
+func TestCompactConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstore")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New(WithDir(dir), WithSegmentSize(1024))
+	defer c.Close()
+	value := bytes.Repeat([]byte("a"), 100)
+	for i := 0; i < 100; i++ {
+		c.Set("key"+strconv.Itoa(i), value)
+	}
+	for i := 0; i < 90; i++ {
+		c.Delete("key" + strconv.Itoa(i))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.Set("new"+strconv.Itoa(i), value)
+			c.Delete("key" + strconv.Itoa(90+i%5))
+			if got, ok := c.Get("key99"); !ok || !bytes.Equal(got, value) {
+				t.Errorf("unexpected value for %q: got %q", "key99", got)
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		c.Compact()
+	}
+	wg.Wait()
+	c.Compact()
+
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if _, ok := c.Get(key); ok != (i >= 95) {
+			t.Errorf("unexpected presence of %q: %t", key, ok)
+		}
+		if got, ok := c.Get("new" + strconv.Itoa(i)); !ok || !bytes.Equal(got, value) {
+			t.Errorf("unexpected value for %q: got %q", "new"+strconv.Itoa(i), got)
+		}
+	}
+}
+
+func sizes(t *testing.T, dir string) []int64 {
+	var sizes []int64
+	for _, match := range segments(t, dir) {
+		s, err := os.Stat(match)
+		if err != nil {
+			t.Fatalf("unexpected error: %q", err)
+		}
+		sizes = append(sizes, s.Size())
+	}
+	return sizes
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func segments(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	return matches
+}
+
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstore")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	cachetest.RunConformance(t, func() httpcache.Cache { return New(WithDir(dir), WithSegmentSize(1<<20)) })
+}
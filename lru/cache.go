@@ -3,9 +3,13 @@ package lru
 import (
 	"container/list"
 	"math"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/freshness"
 )
 
 const defaultSize = 25 << 20 // 25MB
@@ -13,33 +17,96 @@ const defaultSize = 25 << 20 // 25MB
 // Cache is an LRU cache. It is safe for concurrent access.
 // It itself uses a cache for its underlying storage.
 type Cache struct {
-	c     httpcache.Cache
-	mu    sync.Mutex
-	cap   int64
-	items map[string]*item
-	list  *list.List
+	c         httpcache.Cache
+	mu        sync.Mutex
+	size      int64 // capacity in bytes
+	used      int64 // bytes stored
+	items     map[string]*item
+	list      *list.List
+	interval  time.Duration // janitor sweep interval, 0 when disabled
+	grace     time.Duration // time kept past an item's stale window
+	reclaimed int64         // bytes removed by sweeps, accessed atomically
+	evictions [4]int64      // items removed by reason, accessed atomically
+	onEvict   func(key string, size int64, reason Reason)
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Encoder is implemented by underlying caches storing values
+// in a different representation, such as compressed.Cache.
+// Values are encoded before being accounted for so that the
+// capacity of the Cache applies to the stored size.
+type Encoder interface {
+	Encode(resp []byte) []byte
+	SetEncoded(key string, enc []byte)
+}
+
+// Ranger is implemented by underlying caches persisting their
+// values, such as logstore.Cache. A Cache adopts the values of
+// a Ranger on creation, evicting them beyond its capacity.
+type Ranger interface {
+	// Range calls fn from the least to the most recently stored value.
+	Range(fn func(key string, size int64))
+}
+
+// Reason tells why an item was removed from a Cache.
+type Reason int
+
+// Reasons of the removal of items.
+const (
+	Capacity  Reason = iota // evicted to make room for others
+	Expired                 // removed by a sweep
+	Purged                  // explicitly deleted
+	Corrupted               // unreadable from the underlying cache, such as corrupted values set aside
+)
+
+var reasons = [...]string{"capacity", "expired", "purged", "corrupted"}
+
+func (r Reason) String() string {
+	if r < 0 || int(r) >= len(reasons) {
+		return "Reason(" + strconv.Itoa(int(r)) + ")"
+	}
+	return reasons[r]
 }
 
 type item struct {
 	key     string
 	size    uint64
+	expires time.Time // zero when unknown
+	pending bool      // adopted, expires unknown until read
+	gen     uint64    // stores of the value completed
+	writes  int       // stores of the value in flight
 	element *list.Element
 }
 
+type victim struct {
+	key  string
+	size int64
+}
+
 // New creates a new Cache with c as its
 // underlying storage and a capacity of cap bytes.
-func New(options ...func(*Cache)) httpcache.Cache {
+func New(options ...func(*Cache)) *Cache {
 	c := &Cache{
 		c:     defaultCache(),
-		cap:   defaultSize,
+		size:  defaultSize,
 		items: make(map[string]*item),
 		list:  list.New(),
+		done:  make(chan struct{}),
 	}
 
 	for _, option := range options {
 		option(c)
 	}
 
+	if r, ok := c.c.(Ranger); ok {
+		c.adopt(r)
+	}
+
+	if c.interval > 0 {
+		go c.janitor()
+	}
+
 	return c
 }
 
@@ -52,55 +119,260 @@ func (c *Cache) Get(key string) (resp []byte, ok bool) {
 		return
 	}
 	c.list.MoveToFront(item.element)
+	gen, pending := item.gen, item.pending
+	c.mu.Unlock()
+
+	resp, ok = c.c.Get(key)
+	if ok {
+		if pending {
+			c.resolve(item, resp)
+		}
+		return
+	}
+
+	// the value can't be read, such as corrupted values set
+	// aside, unless a value was stored meanwhile
+	c.mu.Lock()
+	corrupted := c.items[key] == item && item.gen == gen && item.writes == 0
+	size := int64(item.size)
+	if corrupted {
+		c.purge(item)
+	}
 	c.mu.Unlock()
-	return c.c.Get(key)
+	if corrupted {
+		c.evict([]victim{{key, size}}, Corrupted)
+	}
+	return
 }
 
 // Set adds or refreshes a value in the cache.
 func (c *Cache) Set(key string, resp []byte) {
-	victims := []string{} // to prevent lock contention of slow storage
+	victims := []victim{} // to prevent lock contention of slow storage
 	var added uint64      // bytes added to cache (can be negative)
+	var expires time.Time
+	store := c.c.Set
+
+	if c.interval > 0 {
+		expires = expiry(resp)
+	}
+
+	if enc, ok := c.c.(Encoder); ok {
+		resp = enc.Encode(resp)
+		store = enc.SetEncoded
+	}
 
 	c.mu.Lock()
-	if itm, exists := c.items[key]; exists {
+	itm, exists := c.items[key]
+	if exists {
 		c.list.MoveToFront(itm.element)
 		added = uint64(len(resp)) - itm.size
 		itm.size = uint64(len(resp))
+		itm.expires = expires
+		itm.pending = false
 	} else {
-		itm := &item{key: key, size: uint64(len(resp))}
+		itm = &item{key: key, size: uint64(len(resp)), expires: expires}
 		itm.element = c.list.PushFront(itm)
 		c.items[key] = itm
 		added = uint64(itm.size)
 	}
-	c.cap -= int64(added)
-	for c.cap < 0 && c.list.Len() > 1 {
+	itm.writes++
+	c.used += int64(added)
+	for c.used > c.size && c.list.Len() > 1 {
 		itm := c.list.Back().Value.(*item)
-		victims = append(victims, itm.key)
+		victims = append(victims, victim{itm.key, int64(itm.size)})
 		c.purge(itm)
 	}
 	c.mu.Unlock()
 
-	for _, key := range victims {
-		c.c.Delete(key)
-	}
-	c.c.Set(key, resp)
+	c.evict(victims, Capacity)
+	store(key, resp)
+
+	c.mu.Lock()
+	itm.writes--
+	itm.gen++
+	c.mu.Unlock()
 }
 
 // Delete removes the provided key from the cache.
 func (c *Cache) Delete(key string) {
 	c.mu.Lock()
-	if item, exists := c.items[key]; exists {
+	item, exists := c.items[key]
+	if exists {
 		c.purge(item)
 	}
 	c.mu.Unlock()
 
+	if exists {
+		c.evict([]victim{{key, int64(item.size)}}, Purged)
+		return
+	}
 	c.c.Delete(key)
 }
 
+// Range calls fn with the key and stored size of every
+// item, from the least to the most recently used.
+func (c *Cache) Range(fn func(key string, size int64)) {
+	c.mu.Lock()
+	items := make([]item, 0, c.list.Len())
+	for e := c.list.Back(); e != nil; e = e.Prev() {
+		items = append(items, *e.Value.(*item))
+	}
+	c.mu.Unlock()
+
+	for _, itm := range items {
+		fn(itm.key, int64(itm.size))
+	}
+}
+
+// Sweep removes the items whose stale window ended more
+// than the configured grace period ago.
+func (c *Cache) Sweep() {
+	victims := []victim{}
+	deadline := time.Now().Add(-c.grace)
+
+	c.mu.Lock()
+	for e := c.list.Front(); e != nil; {
+		itm := e.Value.(*item)
+		e = e.Next()
+		if !itm.expires.IsZero() && itm.expires.Before(deadline) {
+			victims = append(victims, victim{itm.key, int64(itm.size)})
+			atomic.AddInt64(&c.reclaimed, int64(itm.size))
+			c.purge(itm)
+		}
+	}
+	c.mu.Unlock()
+
+	c.evict(victims, Expired)
+}
+
+// Reclaimed returns the number of bytes removed by sweeps.
+func (c *Cache) Reclaimed() int64 {
+	return atomic.LoadInt64(&c.reclaimed)
+}
+
+// Resize changes the capacity of the cache, evicting the
+// least recently used items when shrinking. Sizes beyond
+// math.MaxInt64 are capped to it.
+func (c *Cache) Resize(size uint64) {
+	c.mu.Lock()
+	c.size = capped(size)
+	victims := c.shrink()
+	c.mu.Unlock()
+
+	c.evict(victims, Capacity)
+}
+
+// Used returns the number of bytes stored.
+func (c *Cache) Used() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// Capacity returns the max number of bytes stored.
+func (c *Cache) Capacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Len returns the number of items stored.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list.Len()
+}
+
+// Evicted returns the number of items removed for each Reason.
+func (c *Cache) Evicted() (capacity, expired, purged, corrupted int64) {
+	return atomic.LoadInt64(&c.evictions[Capacity]),
+		atomic.LoadInt64(&c.evictions[Expired]),
+		atomic.LoadInt64(&c.evictions[Purged]),
+		atomic.LoadInt64(&c.evictions[Corrupted])
+}
+
+// Unwrap returns the underlying cache.
+func (c *Cache) Unwrap() httpcache.Cache {
+	return c.c
+}
+
+// Close stops the janitor, if any.
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *Cache) janitor() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Sweep()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Cache) adopt(r Ranger) {
+	r.Range(func(key string, size int64) {
+		itm := &item{key: key, size: uint64(size), pending: c.interval > 0}
+		itm.element = c.list.PushFront(itm)
+		c.items[key] = itm
+		c.used += size
+	})
+
+	c.evict(c.shrink(), Capacity)
+}
+
+// resolve sets the expiry of an adopted item from its value
+// once read, so that sweeps also remove the items stored by
+// previous runs without reading them all.
+func (c *Cache) resolve(itm *item, resp []byte) {
+	expires := expiry(resp)
+
+	c.mu.Lock()
+	if c.items[itm.key] == itm && itm.pending {
+		itm.expires = expires
+		itm.pending = false
+	}
+	c.mu.Unlock()
+}
+
+// shrink purges the least recently used items until the
+// cache fits its capacity and returns them.
+func (c *Cache) shrink() []victim {
+	victims := []victim{}
+	for c.used > c.size && c.list.Len() > 0 {
+		itm := c.list.Back().Value.(*item)
+		victims = append(victims, victim{itm.key, int64(itm.size)})
+		c.purge(itm)
+	}
+	return victims
+}
+
+// evict removes victims from the underlying cache and
+// notifies the eviction handler, if any. Corrupted values
+// are already missing from the underlying cache, where a
+// value may have been stored again since.
+func (c *Cache) evict(victims []victim, reason Reason) {
+	atomic.AddInt64(&c.evictions[reason], int64(len(victims)))
+	for _, v := range victims {
+		if reason != Corrupted {
+			c.c.Delete(v.key)
+		}
+		if c.onEvict != nil {
+			c.onEvict(v.key, v.size, reason)
+		}
+	}
+}
+
 func (c *Cache) purge(item *item) {
 	delete(c.items, item.key)
 	c.list.Remove(item.element)
-	c.cap += int64(item.size)
+	c.used -= int64(item.size)
 }
 
 // WithCache configures a Cache to use a specific
@@ -112,16 +384,48 @@ func WithCache(hc httpcache.Cache) func(*Cache) {
 }
 
 // WithSize configures a Cache to use a specific
-// capacity (in bytes).
+// capacity (in bytes), capped to math.MaxInt64.
 func WithSize(size uint64) func(*Cache) {
-	if size >= math.MaxInt64 {
-		panic("size must fit an int64")
+	return func(c *Cache) {
+		c.size = capped(size)
 	}
+}
+
+// WithJanitor configures a Cache to sweep expired items
+// every interval. Items are removed once their stale window
+// ended more than grace ago.
+func WithJanitor(interval, grace time.Duration) func(*Cache) {
 	return func(c *Cache) {
-		c.cap = int64(size)
+		c.interval = interval
+		c.grace = grace
 	}
 }
 
+// WithEvictionHandler configures a Cache to call fn with
+// the key, stored size and Reason of the items removed from
+// it. fn is called once the items are removed from the
+// underlying cache.
+func WithEvictionHandler(fn func(key string, size int64, reason Reason)) func(*Cache) {
+	return func(c *Cache) {
+		c.onEvict = fn
+	}
+}
+
+func capped(size uint64) int64 {
+	if size > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(size)
+}
+
 func defaultCache() httpcache.Cache {
 	return httpcache.NewMemoryCache()
 }
+
+func expiry(resp []byte) time.Time {
+	f, ok := freshness.Parse(resp)
+	if !ok {
+		return time.Time{}
+	}
+	return f.StaleUntil()
+}
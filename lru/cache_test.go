@@ -3,11 +3,18 @@ package lru
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httputil"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/cachetest"
 )
 
 func TestSet(t *testing.T) {
@@ -97,6 +104,140 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestSweep(t *testing.T) {
+	cache := httpcache.NewMemoryCache()
+	lru := New(WithCache(cache), WithJanitor(time.Hour, time.Minute))
+	defer lru.Close()
+
+	expired := response(time.Now().Add(-2*time.Minute), 0)
+	graced := response(time.Now().Add(-30*time.Second), 0)
+	fresh := response(time.Now(), time.Hour)
+	unknown := randBytes(4)
+
+	lru.Set("expired", expired)
+	lru.Set("graced", graced)
+	lru.Set("fresh", fresh)
+	lru.Set("unknown", unknown)
+	lru.Sweep()
+
+	if _, exists := cache.Get("expired"); exists {
+		t.Errorf("unexpected key '%s' in cache", "expired")
+	}
+
+	for _, key := range []string{"graced", "fresh", "unknown"} {
+		if _, exists := lru.Get(key); !exists {
+			t.Errorf("expected key '%s' to be found in cache", key)
+		}
+	}
+
+	if got, want := lru.Reclaimed(), int64(len(expired)); got != want {
+		t.Errorf("unexpected reclaimed bytes: got %d, want %d", got, want)
+	}
+
+	lru.Set("expired", expired)
+	if got, want := lru.Capacity()-lru.Used(), int64(defaultSize-len(expired)-len(graced)-len(fresh)-len(unknown)); got != want {
+		t.Errorf("unexpected capacity: got %d, want %d", got, want)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	enc := &halver{httpcache.NewMemoryCache()}
+	lru := New(WithCache(enc), WithSize(10))
+
+	lru.Set("key1", randBytes(8)) // stored as 4 bytes
+	lru.Set("key2", randBytes(8)) // stored as 4 bytes
+
+	for _, key := range []string{"key1", "key2"} {
+		if _, exists := lru.Get(key); !exists {
+			t.Errorf("expected key '%s' to be found in cache", key)
+		}
+	}
+
+	if got, want := lru.Capacity()-lru.Used(), int64(2); got != want {
+		t.Errorf("unexpected capacity: got %d, want %d", got, want)
+	}
+}
+
+// halver stores the first half of values.
+type halver struct {
+	httpcache.Cache
+}
+
+func (h *halver) Encode(resp []byte) []byte {
+	return resp[:len(resp)/2]
+}
+
+func (h *halver) SetEncoded(key string, enc []byte) {
+	h.Cache.Set(key, enc)
+}
+
+func TestAdopt(t *testing.T) {
+	storage := &ranger{httpcache.NewMemoryCache(), []string{"key1", "key2", "key3"}}
+	for _, key := range storage.keys {
+		storage.Set(key, randBytes(4))
+	}
+
+	lru := New(WithCache(storage), WithSize(10))
+
+	if _, exists := storage.Get("key1"); exists {
+		t.Errorf("unexpected key '%s' in cache", "key1")
+	}
+
+	for _, key := range []string{"key2", "key3"} {
+		if _, exists := lru.Get(key); !exists {
+			t.Errorf("expected key '%s' to be found in cache", key)
+		}
+	}
+
+	if got, want := lru.Capacity()-lru.Used(), int64(2); got != want {
+		t.Errorf("unexpected capacity: got %d, want %d", got, want)
+	}
+}
+
+// ranger lists its keys in order.
+type ranger struct {
+	httpcache.Cache
+	keys []string
+}
+
+func (r *ranger) Range(fn func(key string, size int64)) {
+	for _, key := range r.keys {
+		b, _ := r.Get(key)
+		fn(key, int64(len(b)))
+	}
+}
+
+func TestSweepAdopted(t *testing.T) {
+	storage := &ranger{httpcache.NewMemoryCache(), []string{"expired", "fresh", "unknown"}}
+	storage.Set("expired", response(time.Now().Add(-2*time.Minute), 0))
+	storage.Set("fresh", response(time.Now(), time.Hour))
+	storage.Set("unknown", randBytes(4))
+
+	lru := New(WithCache(storage), WithJanitor(time.Hour, time.Minute))
+	defer lru.Close()
+
+	lru.Sweep()
+	if _, exists := storage.Get("expired"); !exists {
+		t.Errorf("expected key '%s' not read yet to be kept in storage", "expired")
+	}
+
+	for _, key := range []string{"expired", "fresh", "unknown"} {
+		lru.Get(key)
+	}
+	lru.Sweep()
+	if _, exists := storage.Get("expired"); exists {
+		t.Errorf("unexpected key '%s' in storage", "expired")
+	}
+	for _, key := range []string{"fresh", "unknown"} {
+		if _, exists := lru.Get(key); !exists {
+			t.Errorf("expected key '%s' to be found in cache", key)
+		}
+	}
+	if _, expired, _, _ := lru.Evicted(); expired != 1 {
+		t.Errorf("unexpected expired items: got %d, want %d", expired, 1)
+	}
+}
+
 func TestRace(t *testing.T) {
 	var wg sync.WaitGroup
 	lru := New(WithSize(1024))
@@ -129,3 +270,256 @@ func randBytes(n int) []byte {
 	}
 	return b
 }
+
+func response(date time.Time, maxAge time.Duration) []byte {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Date":          {date.Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=" + strconv.Itoa(int(maxAge.Seconds()))},
+		},
+	}
+	b, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestConformance(t *testing.T) {
+	cachetest.RunConformance(t, func() httpcache.Cache { return New() })
+}
+
+func TestRange(t *testing.T) {
+	c := New()
+	c.Set("key1", []byte("value1"))
+	c.Set("key2", []byte("value22"))
+	c.Set("key3", []byte("value333"))
+	c.Get("key1")
+
+	keys, sizes := []string{}, []int64{}
+	c.Range(func(key string, size int64) {
+		keys = append(keys, key)
+		sizes = append(sizes, size)
+	})
+
+	if got, want := strings.Join(keys, ","), "key2,key3,key1"; got != want {
+		t.Errorf("unexpected keys: got %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(sizes), "[7 8 6]"; got != want {
+		t.Errorf("unexpected sizes: got %s, want %s", got, want)
+	}
+}
+
+func TestOccupancy(t *testing.T) {
+	c := New(WithSize(10))
+	c.Set("key1", randBytes(4))
+	c.Set("key2", randBytes(4))
+	c.Set("key3", randBytes(4))
+	c.Delete("key3")
+
+	if got, want := c.Used(), int64(4); got != want {
+		t.Errorf("unexpected used bytes: got %d, want %d", got, want)
+	}
+	if got, want := c.Capacity(), int64(10); got != want {
+		t.Errorf("unexpected capacity: got %d, want %d", got, want)
+	}
+	if got, want := c.Len(), 1; got != want {
+		t.Errorf("unexpected items: got %d, want %d", got, want)
+	}
+	if got, _, _, _ := c.Evicted(); got != 1 {
+		t.Errorf("unexpected evictions: got %d, want %d", got, 1)
+	}
+}
+
+func TestEvictionHandler(t *testing.T) {
+	storage := httpcache.NewMemoryCache()
+	events := []string{}
+	c := New(WithCache(storage), WithSize(10), WithJanitor(time.Hour, 0), WithEvictionHandler(func(key string, size int64, reason Reason) {
+		if _, exists := storage.Get(key); exists {
+			t.Errorf("unexpected key '%s' in storage", key)
+		}
+		events = append(events, fmt.Sprintf("%s:%d:%s", key, size, reason))
+	}))
+
+	expired := response(time.Now().Add(-time.Hour), 0)
+	c.Set("key1", expired)
+	c.Sweep()
+	c.Set("key2", randBytes(4))
+	c.Set("key3", randBytes(4))
+	c.Set("key4", randBytes(4))
+	c.Delete("key3")
+	c.Delete("missing")
+	c.Set("key5", randBytes(1))
+	storage.Delete("key5")
+	c.Get("key5")
+
+	want := fmt.Sprintf("key1:%d:expired,key2:4:capacity,key3:4:purged,key5:1:corrupted", len(expired))
+	if got := strings.Join(events, ","); got != want {
+		t.Errorf("unexpected events: got %s, want %s", got, want)
+	}
+
+	if got, want := fmt.Sprint(c.Evicted()), "1 1 1 1"; got != want {
+		t.Errorf("unexpected evictions: got %s, want %s", got, want)
+	}
+	if got, want := c.Len(), 1; got != want {
+		t.Errorf("unexpected items: got %d, want %d", got, want)
+	}
+}
+
+func TestCorruptedWhileSet(t *testing.T) {
+	storage := &blocking{Cache: httpcache.NewMemoryCache()}
+	c := New(WithCache(storage))
+	c.Set("key", []byte("value1"))
+
+	// a miss while a value is being stored
+	storage.Delete("key")
+	storage.set = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.Set("key", []byte("value2"))
+		close(done)
+	}()
+	eventually(t, func() bool { return storage.waiting() > 0 })
+	if _, ok := c.Get("key"); ok {
+		t.Errorf("unexpected hit of a value being stored")
+	}
+	close(storage.set)
+	<-done
+
+	// a miss of a value stored meanwhile
+	storage.Delete("key")
+	storage.get = make(chan struct{})
+	got := make(chan bool)
+	go func() {
+		_, ok := c.Get("key")
+		got <- ok
+	}()
+	eventually(t, func() bool { return storage.waiting() > 0 })
+	storage.set = nil
+	c.Set("key", []byte("value3"))
+	close(storage.get)
+	<-got
+
+	if v, ok := c.Get("key"); !ok || string(v) != "value3" {
+		t.Errorf("unexpected value: got %q (%t), want %q", v, ok, "value3")
+	}
+	if _, _, _, corrupted := c.Evicted(); corrupted != 0 {
+		t.Errorf("unexpected corrupted items: got %d, want %d", corrupted, 0)
+	}
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+	}
+}
+
+// blocking is a cache whose gets and sets wait for their
+// channel to be closed, if any.
+type blocking struct {
+	httpcache.Cache
+	get, set chan struct{}
+	mu       sync.Mutex
+	blocked  int
+}
+
+func (b *blocking) Get(key string) ([]byte, bool) {
+	b.wait(b.get)
+	return b.Cache.Get(key)
+}
+
+func (b *blocking) Set(key string, resp []byte) {
+	b.wait(b.set)
+	b.Cache.Set(key, resp)
+}
+
+func (b *blocking) wait(ch chan struct{}) {
+	if ch == nil {
+		return
+	}
+	b.mu.Lock()
+	b.blocked++
+	b.mu.Unlock()
+	<-ch
+	b.mu.Lock()
+	b.blocked--
+	b.mu.Unlock()
+}
+
+func (b *blocking) waiting() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.blocked
+}
+
+func TestResize(t *testing.T) {
+	storage := httpcache.NewMemoryCache()
+	c := New(WithCache(storage), WithSize(12))
+	c.Set("key1", randBytes(4))
+	c.Set("key2", randBytes(4))
+	c.Set("key3", randBytes(4))
+	c.Get("key1")
+
+	c.Resize(8)
+	for _, key := range []string{"key1", "key3"} {
+		if _, exists := c.Get(key); !exists {
+			t.Errorf("expected key '%s' to be found in cache", key)
+		}
+	}
+	if _, exists := storage.Get("key2"); exists {
+		t.Errorf("unexpected key '%s' in storage", "key2")
+	}
+	if got, want := c.Used(), int64(8); got != want {
+		t.Errorf("unexpected used bytes: got %d, want %d", got, want)
+	}
+	if got, _, _, _ := c.Evicted(); got != 1 {
+		t.Errorf("unexpected evictions: got %d, want %d", got, 1)
+	}
+
+	c.Resize(16)
+	c.Set("key4", randBytes(8))
+	if got, want := c.Len(), 3; got != want {
+		t.Errorf("unexpected items once grown: got %d, want %d", got, want)
+	}
+	if got, want := c.Capacity(), int64(16); got != want {
+		t.Errorf("unexpected capacity: got %d, want %d", got, want)
+	}
+
+	c.Resize(math.MaxUint64)
+	if got, want := c.Capacity(), int64(math.MaxInt64); got != want {
+		t.Errorf("unexpected capacity: got %d, want %d", got, want)
+	}
+	if got, want := New(WithSize(math.MaxUint64)).Capacity(), int64(math.MaxInt64); got != want {
+		t.Errorf("unexpected capacity: got %d, want %d", got, want)
+	}
+
+	c.Resize(0)
+	if got, want := c.Len(), 0; got != want {
+		t.Errorf("unexpected items once emptied: got %d, want %d", got, want)
+	}
+}
+
+func TestReasonString(t *testing.T) {
+	tests := []struct {
+		reason Reason
+		want   string
+	}{
+		{Capacity, "capacity"},
+		{Expired, "expired"},
+		{Purged, "purged"},
+		{Corrupted, "corrupted"},
+		{Reason(-1), "Reason(-1)"},
+		{Reason(4), "Reason(4)"},
+	}
+
+	for _, test := range tests {
+		if got := test.reason.String(); got != test.want {
+			t.Errorf("unexpected string of reason %d: got %q, want %q", int(test.reason), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package memcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mikegleasonjr/getcached/internal/remote"
+)
+
+const defaultAddr = "localhost:11211"
+
+// Cache caches requests in a memcached server. Calls failing
+// or exceeding the timeout are treated as misses, and the
+// server is left alone for a while after consecutive failures.
+type Cache struct {
+	options remote.Options
+	pool    *remote.Pool
+}
+
+// New creates a Cache connecting to a memcached server.
+// Connections are established lazily.
+func New(options ...func(*Cache)) *Cache {
+	c := &Cache{options: remote.NewOptions(defaultAddr)}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	c.pool = remote.NewPool(c.options)
+
+	return c
+}
+
+// Get gets an item from the cache.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	err := c.pool.Do(func(conn *remote.Conn) error {
+		fmt.Fprintf(conn.W, "get %s\r\n", c.key(key))
+		if err := conn.W.Flush(); err != nil {
+			return err
+		}
+
+		line, err := readLine(conn)
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return nil
+		}
+
+		var k string
+		var flags, n int
+		if _, err := fmt.Sscanf(line, "VALUE %s %d %d", &k, &flags, &n); err != nil {
+			return fmt.Errorf("unexpected reply %q", line)
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(conn.R, b); err != nil {
+			return err
+		}
+		if string(b[n:]) != "\r\n" {
+			return fmt.Errorf("malformed value")
+		}
+		if line, err := readLine(conn); err != nil || line != "END" {
+			return fmt.Errorf("unexpected reply %q", line)
+		}
+
+		resp, ok = b[:n], true
+		return nil
+	})
+	return resp, ok && err == nil
+}
+
+// Set saves a response to the cache as key.
+func (c *Cache) Set(key string, resp []byte) {
+	c.pool.Do(func(conn *remote.Conn) error {
+		fmt.Fprintf(conn.W, "set %s 0 0 %d\r\n", c.key(key), len(resp))
+		conn.W.Write(resp)
+		conn.W.WriteString("\r\n")
+		if err := conn.W.Flush(); err != nil {
+			return err
+		}
+		return expect(conn, "STORED")
+	})
+}
+
+// Delete deletes an item from the cache.
+func (c *Cache) Delete(key string) {
+	c.pool.Do(func(conn *remote.Conn) error {
+		fmt.Fprintf(conn.W, "delete %s\r\n", c.key(key))
+		if err := conn.W.Flush(); err != nil {
+			return err
+		}
+		return expect(conn, "DELETED", "NOT_FOUND")
+	})
+}
+
+// Close closes the connections to the server.
+func (c *Cache) Close() error {
+	return c.pool.Close()
+}
+
+// key hashes a key, as memcached keys are limited in length
+// and can't contain spaces.
+func (c *Cache) key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return c.options.Prefix + hex.EncodeToString(sum[:])
+}
+
+func expect(conn *remote.Conn, replies ...string) error {
+	line, err := readLine(conn)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if line == reply {
+			return nil
+		}
+	}
+	if strings.HasPrefix(line, "SERVER_ERROR") || strings.HasPrefix(line, "CLIENT_ERROR") {
+		return remote.ReplyError(line)
+	}
+	return fmt.Errorf("unexpected reply %q", line)
+}
+
+func readLine(conn *remote.Conn) (string, error) {
+	line, err := conn.R.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("malformed reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// WithAddr sets the address of the server.
+func WithAddr(addr string) func(*Cache) {
+	return func(c *Cache) {
+		c.options.Addr = addr
+	}
+}
+
+// WithPrefix sets a prefix added to the keys, to share a
+// server with other applications.
+func WithPrefix(prefix string) func(*Cache) {
+	return func(c *Cache) {
+		c.options.Prefix = prefix
+	}
+}
+
+// WithTimeout sets the max duration getting a connection to
+// the server, after which a call is treated as a miss.
+func WithTimeout(timeout time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.options.Timeout = timeout
+	}
+}
+
+// WithIOTimeout sets the max duration the server can take to
+// accept or send the next bytes of a call, after which the call
+// is treated as a miss. Calls transferring large values are not
+// bounded as long as the server makes progress.
+func WithIOTimeout(timeout time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.options.IOTimeout = timeout
+	}
+}
+
+// WithPoolSize sets the max number of connections.
+func WithPoolSize(size int) func(*Cache) {
+	return func(c *Cache) {
+		c.options.PoolSize = size
+	}
+}
+
+// WithBreaker configures the number of consecutive failures
+// after which the server is not called for a cooldown period.
+// A threshold of 0 disables the breaker.
+func WithBreaker(threshold int, cooldown time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.options.Threshold = threshold
+		c.options.Cooldown = cooldown
+	}
+}
@@ -0,0 +1,200 @@
+package memcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/cachetest"
+)
+
+func TestConformance(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	cachetest.RunConformance(t, func() httpcache.Cache { return New(WithAddr(s.Addr()), WithTimeout(time.Second)) })
+}
+
+func TestKey(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := New(WithAddr(s.Addr()), WithPrefix("getcached:"))
+	defer c.Close()
+
+	c.Set("http://example.com/ content-encoding=gzip", []byte("value"))
+	for _, key := range s.keys() {
+		if !strings.HasPrefix(key, "getcached:") || strings.ContainsAny(key, " \r\n") || len(key) > 250 {
+			t.Errorf("unexpected stored key: %q", key)
+		}
+	}
+}
+
+func TestSlowServer(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := New(WithAddr(s.Addr()), WithIOTimeout(50*time.Millisecond), WithBreaker(2, time.Hour))
+	defer c.Close()
+
+	c.Set("key", []byte("value"))
+	s.stall(true)
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if _, ok := c.Get("key"); ok {
+			t.Errorf("unexpected hit from a stalled server")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("unexpected call duration: got %v, want < %v", elapsed, time.Second)
+		}
+	}
+
+	s.stall(false)
+	calls := s.calls()
+	if _, ok := c.Get("key"); ok {
+		t.Errorf("unexpected hit with an open circuit")
+	}
+	if got := s.calls(); got != calls {
+		t.Errorf("unexpected calls with an open circuit: got %d, want %d", got, calls)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := New(WithAddr(s.Addr()), WithTimeout(time.Second), WithBreaker(1, time.Hour))
+	defer c.Close()
+
+	c.Set("key", make([]byte, maxValueSize+1)) // rejected by the server
+	c.Set("key", []byte("value"))
+	if got, ok := c.Get("key"); !ok || string(got) != "value" {
+		t.Errorf("unexpected value after a server error: got %q (%t), want %q", got, ok, "value")
+	}
+}
+
+const maxValueSize = 8 << 20 // 8MB
+
+// fakeServer is an in-process server speaking enough of the
+// memcached text protocol for Cache.
+type fakeServer struct {
+	l       net.Listener
+	mu      sync.Mutex
+	values  map[string][]byte
+	stalled int32
+	n       int32
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	s := &fakeServer{l: l, values: map[string][]byte{}}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string { return s.l.Addr().String() }
+func (s *fakeServer) Close() error { return s.l.Close() }
+
+func (s *fakeServer) stall(stalled bool) {
+	var v int32
+	if stalled {
+		v = 1
+	}
+	atomic.StoreInt32(&s.stalled, v)
+}
+
+func (s *fakeServer) calls() int32 { return atomic.LoadInt32(&s.n) }
+
+func (s *fakeServer) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) < 2 {
+			return
+		}
+
+		var value []byte
+		if args[0] == "set" {
+			var n int
+			if _, err := fmt.Sscanf(line, "set %s 0 0 %d", new(string), &n); err != nil {
+				return
+			}
+			value = make([]byte, n+2)
+			if _, err := io.ReadFull(r, value); err != nil {
+				return
+			}
+			value = value[:n]
+		}
+
+		atomic.AddInt32(&s.n, 1)
+		if atomic.LoadInt32(&s.stalled) == 1 {
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+
+		s.mu.Lock()
+		switch args[0] {
+		case "get":
+			if v, ok := s.values[args[1]]; ok {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n", args[1], len(v))
+				w.Write(v)
+				w.WriteString("\r\n")
+			}
+			w.WriteString("END\r\n")
+		case "set":
+			if len(value) > maxValueSize {
+				w.WriteString("SERVER_ERROR object too large for cache\r\n")
+				break
+			}
+			s.values[args[1]] = value
+			w.WriteString("STORED\r\n")
+		case "delete":
+			if _, ok := s.values[args[1]]; ok {
+				delete(s.values, args[1])
+				w.WriteString("DELETED\r\n")
+			} else {
+				w.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			w.WriteString("ERROR\r\n")
+		}
+		s.mu.Unlock()
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -2,6 +2,7 @@ package getcached
 
 import (
 	"sync/atomic"
+	"time"
 
 	"github.com/gregjones/httpcache"
 )
@@ -15,6 +16,89 @@ type Stats struct {
 	Sets      int64 // total sets
 	SetsBytes int64 // total sets (in bytes)
 	Deletes   int64 // total deletes
+	Reclaimed int64 // bytes reclaimed by expiry sweeps
+	RawBytes  int64 // bytes given to compression
+	Stored    int64 // bytes stored after compression
+	Corrupted int64 // corrupted entries quarantined
+	Used      int64 // bytes currently stored
+	Capacity  int64 // max bytes stored
+	Items     int64 // entries currently stored
+	Evicted   int64 // entries evicted to make room for others
+	Expired   int64 // entries removed by expiry sweeps
+	Purged    int64 // entries explicitly deleted
+	Discarded int64 // entries discarded as their stored value was corrupted
+
+	GetLatency    Distribution // durations of gets (in nanoseconds)
+	SetLatency    Distribution // durations of sets (in nanoseconds)
+	DeleteLatency Distribution // durations of deletes (in nanoseconds)
+	Sizes         Distribution // sizes of the values set (in bytes)
+}
+
+// CompressionRatio returns the ratio of the bytes given to
+// compression over the bytes actually stored, or 1 if the
+// monitored cache does not compress.
+func (s *Stats) CompressionRatio() float64 {
+	if s.Stored == 0 {
+		return 1
+	}
+	return float64(s.RawBytes) / float64(s.Stored)
+}
+
+// Reclaimer is implemented by caches which proactively
+// remove expired entries.
+type Reclaimer interface {
+	Reclaimed() int64
+}
+
+// Compressor is implemented by caches storing compressed
+// values, such as compressed.Cache.
+type Compressor interface {
+	Compression() (raw, stored int64)
+}
+
+// Quarantiner is implemented by caches which set aside
+// corrupted entries, such as disk.Cache.
+type Quarantiner interface {
+	Corrupted() int64
+}
+
+// Sizer is implemented by caches of a bounded capacity,
+// such as lru.Cache.
+type Sizer interface {
+	Used() int64
+	Capacity() int64
+	Len() int
+}
+
+// Evictor is implemented by caches counting the entries
+// they remove by reason, such as lru.Cache.
+type Evictor interface {
+	Evicted() (capacity, expired, purged, corrupted int64)
+}
+
+// Wrapper is implemented by cache decorators. A Monitor
+// reports the statistics of every cache of a chain of
+// decorators implementing Reclaimer, Compressor, Quarantiner,
+// Sizer or Evictor.
+type Wrapper interface {
+	Unwrap() httpcache.Cache
+}
+
+// Find returns the first cache of the chain of decorators
+// starting at c for which fn returns true, or nil if there
+// is none. Decorators are unwrapped through Wrapper.
+func Find(c httpcache.Cache, fn func(httpcache.Cache) bool) httpcache.Cache {
+	for c != nil {
+		if fn(c) {
+			return c
+		}
+		w, ok := c.(Wrapper)
+		if !ok {
+			break
+		}
+		c = w.Unwrap()
+	}
+	return nil
 }
 
 // Monitor is a cache decorator which keeps tracks
@@ -30,16 +114,57 @@ type Monitor struct {
 	sets      AtomicInt
 	setsBytes AtomicInt
 	deletes   AtomicInt
+
+	latencyBuckets []int64
+	sizeBuckets    []int64
+	getLatency     *Histogram
+	setLatency     *Histogram
+	deleteLatency  *Histogram
+	sizes          *Histogram
 }
 
 // NewMonitor creates a Monitor.
-func NewMonitor(c httpcache.Cache) *Monitor {
-	return &Monitor{c: c}
+func NewMonitor(c httpcache.Cache, options ...func(*Monitor)) *Monitor {
+	m := &Monitor{
+		c:              c,
+		latencyBuckets: DefaultLatencyBuckets,
+		sizeBuckets:    DefaultSizeBuckets,
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	m.getLatency = NewHistogram(m.latencyBuckets...)
+	m.setLatency = NewHistogram(m.latencyBuckets...)
+	m.deleteLatency = NewHistogram(m.latencyBuckets...)
+	m.sizes = NewHistogram(m.sizeBuckets...)
+
+	return m
+}
+
+// WithLatencyBuckets configures the upper bounds of the
+// latency histograms of a Monitor.
+func WithLatencyBuckets(bounds ...time.Duration) func(*Monitor) {
+	return func(m *Monitor) {
+		m.latencyBuckets = make([]int64, len(bounds))
+		for i, b := range bounds {
+			m.latencyBuckets[i] = int64(b)
+		}
+	}
+}
+
+// WithSizeBuckets configures the upper bounds, in bytes,
+// of the size histogram of a Monitor.
+func WithSizeBuckets(bounds ...int64) func(*Monitor) {
+	return func(m *Monitor) {
+		m.sizeBuckets = bounds
+	}
 }
 
 // Stats returns the current cache stats.
 func (m *Monitor) Stats() *Stats {
-	return &Stats{
+	s := &Stats{
 		Gets:      m.gets.Get(),
 		Hits:      m.hits.Get(),
 		HitsBytes: m.hitsBytes.Get(),
@@ -47,14 +172,51 @@ func (m *Monitor) Stats() *Stats {
 		Sets:      m.sets.Get(),
 		SetsBytes: m.setsBytes.Get(),
 		Deletes:   m.deletes.Get(),
+
+		GetLatency:    m.getLatency.Distribution(),
+		SetLatency:    m.setLatency.Distribution(),
+		DeleteLatency: m.deleteLatency.Distribution(),
+		Sizes:         m.sizes.Distribution(),
 	}
+
+	Find(m.c, func(c httpcache.Cache) bool {
+		if r, ok := c.(Reclaimer); ok {
+			s.Reclaimed += r.Reclaimed()
+		}
+		if comp, ok := c.(Compressor); ok {
+			raw, stored := comp.Compression()
+			s.RawBytes += raw
+			s.Stored += stored
+		}
+		if q, ok := c.(Quarantiner); ok {
+			s.Corrupted += q.Corrupted()
+		}
+		if sz, ok := c.(Sizer); ok {
+			s.Used += sz.Used()
+			s.Capacity += sz.Capacity()
+			s.Items += int64(sz.Len())
+		}
+		if e, ok := c.(Evictor); ok {
+			capacity, expired, purged, corrupted := e.Evicted()
+			s.Evicted += capacity
+			s.Expired += expired
+			s.Purged += purged
+			s.Discarded += corrupted
+		}
+		return false // every cache of the chain counts
+	})
+
+	return s
 }
 
 // Get implements httpcache.Cache.
 func (m *Monitor) Get(key string) ([]byte, bool) {
 	m.gets.Add(1)
 
+	start := time.Now()
 	b, hit := m.c.Get(key)
+	m.getLatency.Observe(int64(time.Since(start)))
+
 	if hit {
 		m.hits.Add(1)
 		m.hitsBytes.Add(int64(len(b)))
@@ -69,15 +231,25 @@ func (m *Monitor) Get(key string) ([]byte, bool) {
 func (m *Monitor) Set(key string, resp []byte) {
 	m.sets.Add(1)
 	m.setsBytes.Add(int64(len(resp)))
+	m.sizes.Observe(int64(len(resp)))
 
+	start := time.Now()
 	m.c.Set(key, resp)
+	m.setLatency.Observe(int64(time.Since(start)))
 }
 
 // Delete implements httpcache.Cache.
 func (m *Monitor) Delete(key string) {
 	m.deletes.Add(1)
 
+	start := time.Now()
 	m.c.Delete(key)
+	m.deleteLatency.Observe(int64(time.Since(start)))
+}
+
+// Unwrap returns the monitored cache.
+func (m *Monitor) Unwrap() httpcache.Cache {
+	return m.c
 }
 
 // An AtomicInt is an int64 to be accessed atomically.
@@ -5,6 +5,10 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/cachetest"
+	"github.com/mikegleasonjr/getcached/lru"
 	"github.com/mikegleasonjr/getcached/mocks"
 )
 
@@ -12,8 +16,8 @@ func TestStats(t *testing.T) {
 	cache := new(mocks.Cache)
 	defer cache.AssertExpectations(t)
 
-	mon := NewMonitor(cache)
-	want := &Stats{}
+	mon := NewMonitor(cache, WithSizeBuckets(10, 100))
+	want := &Stats{Sizes: Distribution{Bounds: []int64{10, 100}, Counts: []int64{0, 0, 0}}}
 
 	cache.On("Get", "hit10").Once().Return(randBytes(10), true)
 	want.Gets++
@@ -30,6 +34,9 @@ func TestStats(t *testing.T) {
 	cache.On("Set", "set20", b).Once()
 	want.Sets++
 	want.SetsBytes += 20
+	want.Sizes.Counts[1]++
+	want.Sizes.Count++
+	want.Sizes.Sum += 20
 	mon.Set("set20", b)
 
 	cache.On("Delete", "del").Once()
@@ -37,9 +44,107 @@ func TestStats(t *testing.T) {
 	mon.Delete("del")
 
 	got := mon.Stats()
-	if diff := cmp.Diff(got, want); diff != "" {
+	latencies := cmpopts.IgnoreFields(Stats{}, "GetLatency", "SetLatency", "DeleteLatency")
+	if diff := cmp.Diff(got, want, latencies); diff != "" {
 		t.Errorf("stats mismatch (-want +got):\n%s", diff)
 	}
+
+	if got, want := got.GetLatency.Count, int64(2); got != want {
+		t.Errorf("unexpected get latency count: got %d, want %d", got, want)
+	}
+	if got, want := got.SetLatency.Count, int64(1); got != want {
+		t.Errorf("unexpected set latency count: got %d, want %d", got, want)
+	}
+	if got, want := got.DeleteLatency.Count, int64(1); got != want {
+		t.Errorf("unexpected delete latency count: got %d, want %d", got, want)
+	}
+}
+
+func TestStatsReclaimed(t *testing.T) {
+	mon := NewMonitor(reclaimer{new(mocks.Cache), 42})
+
+	if got, want := mon.Stats().Reclaimed, int64(42); got != want {
+		t.Errorf("unexpected reclaimed bytes: got %d, want %d", got, want)
+	}
+}
+
+func TestStatsCompression(t *testing.T) {
+	mon := NewMonitor(wrapper{compressor{new(mocks.Cache)}})
+
+	s := mon.Stats()
+	if s.RawBytes != 300 || s.Stored != 100 {
+		t.Errorf("unexpected compression stats: got %d/%d, want %d/%d", s.RawBytes, s.Stored, 300, 100)
+	}
+
+	if got, want := s.CompressionRatio(), 3.0; got != want {
+		t.Errorf("unexpected compression ratio: got %f, want %f", got, want)
+	}
+}
+
+type wrapper struct {
+	httpcache.Cache
+}
+
+func (w wrapper) Unwrap() httpcache.Cache {
+	return w.Cache
+}
+
+type compressor struct {
+	*mocks.Cache
+}
+
+func (c compressor) Compression() (raw, stored int64) {
+	return 300, 100
+}
+
+func TestStatsCorrupted(t *testing.T) {
+	mon := NewMonitor(wrapper{wrapper{quarantiner{new(mocks.Cache)}}})
+
+	if got, want := mon.Stats().Corrupted, int64(3); got != want {
+		t.Errorf("unexpected corrupted entries: got %d, want %d", got, want)
+	}
+}
+
+func TestStatsOccupancy(t *testing.T) {
+	mon := NewMonitor(wrapper{sizer{new(mocks.Cache)}})
+
+	s := mon.Stats()
+	if s.Used != 60 || s.Capacity != 100 || s.Items != 2 {
+		t.Errorf("unexpected occupancy: got %d/%d bytes, %d items, want %d/%d bytes, %d items",
+			s.Used, s.Capacity, s.Items, 60, 100, 2)
+	}
+	if s.Evicted != 5 || s.Expired != 4 || s.Purged != 3 || s.Discarded != 2 {
+		t.Errorf("unexpected evictions: got %d/%d/%d/%d, want %d/%d/%d/%d",
+			s.Evicted, s.Expired, s.Purged, s.Discarded, 5, 4, 3, 2)
+	}
+}
+
+type sizer struct {
+	*mocks.Cache
+}
+
+func (s sizer) Used() int64     { return 60 }
+func (s sizer) Capacity() int64 { return 100 }
+func (s sizer) Len() int        { return 2 }
+func (s sizer) Evicted() (capacity, expired, purged, corrupted int64) {
+	return 5, 4, 3, 2
+}
+
+type quarantiner struct {
+	*mocks.Cache
+}
+
+func (q quarantiner) Corrupted() int64 {
+	return 3
+}
+
+type reclaimer struct {
+	*mocks.Cache
+	reclaimed int64
+}
+
+func (r reclaimer) Reclaimed() int64 {
+	return r.reclaimed
 }
 
 func randBytes(n int) []byte {
@@ -50,3 +155,25 @@ func randBytes(n int) []byte {
 	}
 	return b
 }
+
+func TestFind(t *testing.T) {
+	memory := lru.New()
+	c := NewMonitor(memory)
+
+	if got := Find(c, func(c httpcache.Cache) bool { _, ok := c.(*lru.Cache); return ok }); got != memory {
+		t.Errorf("unexpected cache found: got %T, want %T", got, memory)
+	}
+	if got := Find(c, func(httpcache.Cache) bool { return true }); got != c {
+		t.Errorf("unexpected cache found: got %T, want %T", got, c)
+	}
+	if got := Find(c, func(httpcache.Cache) bool { return false }); got != nil {
+		t.Errorf("unexpected cache found: got %T, want none", got)
+	}
+	if got := Find(nil, func(httpcache.Cache) bool { return true }); got != nil {
+		t.Errorf("unexpected cache found: got %T, want none", got)
+	}
+}
+
+func TestMonitorConformance(t *testing.T) {
+	cachetest.RunConformance(t, func() httpcache.Cache { return NewMonitor(httpcache.NewMemoryCache()) })
+}
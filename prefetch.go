@@ -0,0 +1,227 @@
+package getcached
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mikegleasonjr/getcached/freshness"
+)
+
+const defaultPrefetchConcurrency = 4
+
+// ErrSitemapIndex is returned when reading a sitemap index
+// instead of a sitemap.
+var ErrSitemapIndex = errors.New("sitemap indexes are not supported")
+
+// PrefetchStats counts the outcomes of a prefetch. Whether
+// a proxy keeps a response is told by its Cache-Status entry,
+// if it has WithCacheStatus, or guessed from the response
+// headers otherwise.
+type PrefetchStats struct {
+	Cached      int64 // responses the proxies keep
+	Uncacheable int64 // responses the proxies can't keep or reuse
+	Failed      int64 // requests failing or answered with an error
+}
+
+// Prefetch requests urls through the proxies owning them so
+// they get cached. Requests are made concurrently and, if
+// configured, at a limited rate. It returns early if ctx is
+// done.
+func (c *Client) Prefetch(ctx context.Context, urls []string) (PrefetchStats, error) {
+	var stats PrefetchStats
+	var wg sync.WaitGroup
+
+	workers := c.concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range queue {
+				atomic.AddInt64(c.prefetch(ctx, u, &stats), 1)
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if c.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / c.rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	err := func() error {
+		defer close(queue)
+		for i, u := range urls {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if tick != nil && i > 0 {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			select {
+			case queue <- u:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}()
+
+	wg.Wait()
+	return stats, err
+}
+
+// prefetch requests a url and returns the counter of its outcome.
+func (c *Client) prefetch(ctx context.Context, u string, stats *PrefetchStats) *int64 {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return &stats.Failed
+	}
+
+	res, err := c.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return &stats.Failed
+	}
+	defer res.Body.Close()
+
+	// proxies store responses once fully read
+	if _, err := io.Copy(ioutil.Discard, res.Body); err != nil || res.StatusCode >= http.StatusBadRequest {
+		return &stats.Failed
+	}
+
+	kept, ok := keptByProxy(res.Header)
+	if !ok {
+		kept = reusable(res.Header)
+	}
+	if !kept {
+		return &stats.Uncacheable
+	}
+	return &stats.Cached
+}
+
+// keptByProxy tells if the proxy answering a request served the
+// response from its cache or stored it, as told by its entry of
+// the Cache-Status header, the last one. It returns false if
+// there is none.
+func keptByProxy(h http.Header) (kept bool, ok bool) {
+	values := h[CacheStatusHeader]
+	if len(values) == 0 {
+		return false, false
+	}
+	entries := splitList(strings.Join(values, ","), ',')
+	params := splitList(entries[len(entries)-1], ';')
+	for _, param := range params[1:] {
+		switch strings.TrimSpace(param) {
+		case "hit", "stored":
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// splitList splits a structured field list around sep,
+// except within quoted strings.
+func splitList(s string, sep rune) []string {
+	parts := []string{}
+	quoted, escaped, start := false, false, 0
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// reusable tells if a cached response can be served again,
+// either while fresh or after being revalidated.
+func reusable(h http.Header) bool {
+	for _, v := range h["Vary"] {
+		for _, field := range strings.Split(v, ",") {
+			if strings.TrimSpace(field) == "*" {
+				return false
+			}
+		}
+	}
+
+	for _, v := range h["Cache-Control"] {
+		for _, directive := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+				return false
+			}
+		}
+	}
+
+	if f, ok := freshness.FromHeader(h); ok && f.Fresh > 0 {
+		return true
+	}
+	return h.Get("Etag") != "" || h.Get("Last-Modified") != ""
+}
+
+// ReadURLs reads URLs from a sitemap or from a list with one
+// URL per line. Blank lines and lines starting with # are
+// ignored.
+func ReadURLs(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+	if start, _ := br.Peek(512); strings.HasPrefix(strings.TrimSpace(string(start)), "<") {
+		return readSitemap(br)
+	}
+
+	urls := []string{}
+	s := bufio.NewScanner(br)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, s.Err()
+}
+
+func readSitemap(r io.Reader) ([]string, error) {
+	var sitemap struct {
+		XMLName xml.Name
+		URLs    []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+	}
+	if err := xml.NewDecoder(r).Decode(&sitemap); err != nil {
+		return nil, err
+	}
+	if sitemap.XMLName.Local == "sitemapindex" {
+		return nil, ErrSitemapIndex
+	}
+
+	urls := make([]string, 0, len(sitemap.URLs))
+	for _, u := range sitemap.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			urls = append(urls, loc)
+		}
+	}
+	return urls, nil
+}
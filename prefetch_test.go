@@ -0,0 +1,169 @@
+package getcached
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gregjones/httpcache"
+)
+
+func TestPrefetch(t *testing.T) {
+	var inflight, maxInflight, calls int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		for {
+			max := atomic.LoadInt64(&maxInflight)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInflight, max, n) {
+				break
+			}
+		}
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/fresh"):
+			w.Header().Set("Cache-Control", "max-age=60")
+		case strings.HasPrefix(r.URL.Path, "/validated"):
+			w.Header().Set("Etag", `"v1"`)
+		case strings.HasPrefix(r.URL.Path, "/nostore"):
+			w.Header().Set("Cache-Control", "no-store")
+		case strings.HasPrefix(r.URL.Path, "/vary"):
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "*")
+		case strings.HasPrefix(r.URL.Path, "/error"):
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte("content"))
+	}))
+	defer origin.Close()
+
+	proxy := httptest.NewServer(New(WithCache(httpcache.NewMemoryCache())))
+	defer proxy.Close()
+
+	c := NewClient(WithPrefetchConcurrency(2))
+	c.Set(proxy.URL)
+
+	urls := []string{}
+	for _, path := range []string{"/fresh1", "/fresh2", "/validated", "/nostore", "/vary", "/error"} {
+		urls = append(urls, origin.URL+path)
+	}
+	urls = append(urls, "http://127.0.0.1:0/unreachable")
+
+	stats, err := c.Prefetch(context.Background(), urls)
+	if err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+	if diff := cmp.Diff(PrefetchStats{Cached: 3, Uncacheable: 2, Failed: 2}, stats); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+	if got, want := atomic.LoadInt64(&maxInflight), int64(2); got > want {
+		t.Errorf("unexpected concurrency: got %d, want <= %d", got, want)
+	}
+
+	before := atomic.LoadInt64(&calls)
+	if _, err := c.Prefetch(context.Background(), urls[:2]); err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+	if got := atomic.LoadInt64(&calls); got != before {
+		t.Errorf("unexpected origin calls for cached urls: got %d, want %d", got, before)
+	}
+}
+
+func TestPrefetchCacheStatus(t *testing.T) {
+	statuses := map[string][]string{
+		"/stored":    {"node; fwd=uri-miss; stored; ttl=60"},
+		"/hit":       {"node; hit; ttl=60"},
+		"/private":   {"node; fwd=uri-miss; detail=private"},
+		"/oversized": {"node; fwd=uri-miss"},
+		"/chained":   {`"cdn, edge"; hit`, "node; fwd=uri-miss"},
+		"/quoted":    {`node; fwd=uri-miss, "a; hit"; stored`},
+		"/none":      nil,
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := url.Parse(r.URL.Query().Get("q"))
+		w.Header()["Cache-Status"] = statuses[u.Path]
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		w.Write([]byte("content"))
+	}))
+	defer proxy.Close()
+
+	c := NewClient()
+	c.Set(proxy.URL)
+
+	for path, want := range map[string]PrefetchStats{
+		"/stored":    {Cached: 1},
+		"/hit":       {Cached: 1},
+		"/private":   {Uncacheable: 1},
+		"/oversized": {Uncacheable: 1},
+		"/chained":   {Uncacheable: 1},
+		"/quoted":    {Cached: 1},
+		"/none":      {Cached: 1},
+	} {
+		stats, err := c.Prefetch(context.Background(), []string{"http://origin" + path})
+		if err != nil {
+			t.Errorf("unexpected error of %s: %q", path, err)
+		}
+		if diff := cmp.Diff(want, stats); diff != "" {
+			t.Errorf("unexpected stats of %s (-want +got):\n%s", path, diff)
+		}
+	}
+}
+
+func TestPrefetchRate(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	proxy := httptest.NewServer(New(WithCache(httpcache.NewMemoryCache())))
+	defer proxy.Close()
+
+	c := NewClient(WithPrefetchConcurrency(4), WithPrefetchRate(50))
+	c.Set(proxy.URL)
+
+	start := time.Now()
+	c.Prefetch(context.Background(), []string{origin.URL + "/1", origin.URL + "/2", origin.URL + "/3", origin.URL + "/4", origin.URL + "/5"})
+	if elapsed, want := time.Since(start), 80*time.Millisecond; elapsed < want {
+		t.Errorf("unexpected duration: got %v, want >= %v", elapsed, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Prefetch(ctx, []string{origin.URL + "/1", origin.URL + "/2"}); err != context.Canceled {
+		t.Errorf("unexpected error: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReadURLs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  error
+	}{
+		{"http://a/1\n\n# comment\n  http://a/2  \n", []string{"http://a/1", "http://a/2"}, nil},
+		{`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://a/1</loc><lastmod>2019-01-01</lastmod></url>
+  <url><loc> http://a/2 </loc></url>
+</urlset>`, []string{"http://a/1", "http://a/2"}, nil},
+		{`<sitemapindex><sitemap><loc>http://a/sitemap.xml</loc></sitemap></sitemapindex>`, nil, ErrSitemapIndex},
+	}
+
+	for _, test := range tests {
+		got, err := ReadURLs(strings.NewReader(test.in))
+		if err != test.err {
+			t.Errorf("unexpected error: got %v, want %v", err, test.err)
+		}
+		if test.err == nil {
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected urls (-want +got):\n%s", diff)
+			}
+		}
+	}
+}
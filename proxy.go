@@ -3,11 +3,15 @@ package getcached
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
 
 	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/compressed"
+	"github.com/mikegleasonjr/getcached/trace"
 )
 
 type key struct{}
@@ -16,18 +20,29 @@ var originKey = key{}
 
 // Proxy is a caching proxy server.
 type Proxy struct {
-	rp *httputil.ReverseProxy
-	tr *httpcache.Transport
+	rp     *httputil.ReverseProxy
+	tr     *httpcache.Transport
+	orig   *fetcher
+	vary   *varier
+	enc    *encoder
+	log    *AccessLog
+	trc    *trace.Tracer
+	status *cacheStatus
 }
 
 // New creates a Proxy using options.
 func New(options ...func(*Proxy)) *Proxy {
+	orig := &fetcher{}
 	tr := httpcache.NewTransport(httpcache.NewMemoryCache())
+	tr.Transport = orig
+	vary := &varier{tr: tr, max: defaultMaxVariants}
 
 	p := &Proxy{
-		tr: tr,
+		tr:   tr,
+		orig: orig,
+		vary: vary,
 		rp: &httputil.ReverseProxy{
-			Transport: tr,
+			Transport: vary,
 			Director: func(req *http.Request) {
 				origin := req.Context().Value(originKey).(*url.URL)
 				req.URL = origin
@@ -45,6 +60,30 @@ func New(options ...func(*Proxy)) *Proxy {
 
 // ServeHTTP enables Proxy to be used as an http.Handler.
 func (p *Proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	req, e := WithExchange(req)
+	w := &responseRecorder{ResponseWriter: rw}
+	rw = w
+
+	if p.status != nil {
+		w.before = func() { p.status.apply(w.Header(), req, e) }
+	}
+	if p.log != nil {
+		defer p.log.log(req, w, e, time.Now())
+	}
+	if p.trc != nil {
+		ctx, span := p.trc.Start(trace.Extract(req.Context(), req.Header), "getcached.proxy", trace.Server)
+		req = req.WithContext(ctx)
+		defer func() {
+			span.SetAttribute("http.method", req.Method)
+			span.SetAttribute("http.status_code", w.Status())
+			span.SetAttribute("cache.status", string(e.CacheStatus()))
+			if e.Origin != nil {
+				span.SetAttribute("url.full", e.Origin.String())
+			}
+			span.Finish(nil)
+		}()
+	}
+
 	q := req.URL.Query().Get("q")
 	if q == "" {
 		rw.WriteHeader(http.StatusBadGateway)
@@ -57,15 +96,17 @@ func (p *Proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	e.Origin = origin
 	ctx := context.WithValue(req.Context(), originKey, origin)
 	p.rp.ServeHTTP(rw, req.WithContext(ctx))
+	e.FromCache = rw.Header().Get(httpcache.XFromCache) == "1"
 }
 
 // WithProxyTransport configures a Proxy to use
 // a specific http.RoundTripper.
 func WithProxyTransport(tr http.RoundTripper) func(*Proxy) {
 	return func(p *Proxy) {
-		p.tr.Transport = tr
+		p.orig.next = tr
 	}
 }
 
@@ -84,9 +125,63 @@ func WithErrorLogger(l *log.Logger) func(*Proxy) {
 	}
 }
 
+// WithAccessLog configures a Proxy to record the requests
+// it serves in an AccessLog.
+func WithAccessLog(l *AccessLog) func(*Proxy) {
+	return func(p *Proxy) {
+		p.log = l
+	}
+}
+
+// WithTracer configures a Proxy to trace the requests it
+// serves, continuing the traces propagated by clients.
+func WithTracer(t *trace.Tracer) func(*Proxy) {
+	return func(p *Proxy) {
+		p.trc = t
+	}
+}
+
+// WithCacheStatus configures a Proxy to describe how it
+// handled requests in the Cache-Status header (RFC 9211) of
+// its responses, as the cache named node. Cached responses are
+// named after the node and the Tier serving them, such as
+// "node/memory". When internal networks are given, the
+// Cache-Status header is removed from the responses to other
+// clients, including the entries of caches upstream.
+func WithCacheStatus(node string, internal ...*net.IPNet) func(*Proxy) {
+	return func(p *Proxy) {
+		p.status = &cacheStatus{node: node, internal: internal}
+	}
+}
+
 // WithBufferPool configures a Proxy to use a BufferPool.
 func WithBufferPool(pool httputil.BufferPool) func(*Proxy) {
 	return func(p *Proxy) {
 		p.rp.BufferPool = pool
 	}
 }
+
+// WithMaxVariants configures the maximum number of variants
+// of a resource a Proxy caches when responses have a Vary
+// header. The least recently stored variants are evicted first.
+func WithMaxVariants(max int) func(*Proxy) {
+	return func(p *Proxy) {
+		p.vary.max = max
+	}
+}
+
+// WithContentEncoding configures a Proxy to serve responses
+// in the content coding (as in Accept-Encoding) with a
+// specific compressed.Codec to clients accepting it. Responses
+// are decoded for clients not accepting their content coding.
+// Calling it multiple times registers content codings by order
+// of preference.
+func WithContentEncoding(coding string, codec compressed.Codec) func(*Proxy) {
+	return func(p *Proxy) {
+		if p.enc == nil {
+			p.enc = &encoder{tr: p.tr, next: p.vary, codecs: map[string]compressed.Codec{}}
+			p.rp.Transport = p.enc
+		}
+		p.enc.add(coding, codec)
+	}
+}
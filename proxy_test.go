@@ -1,6 +1,8 @@
 package getcached
 
 import (
+	"bytes"
+	"compress/gzip"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/compressed"
 	"github.com/mikegleasonjr/getcached/mocks"
 	"github.com/stretchr/testify/mock"
 )
@@ -19,7 +22,7 @@ func TestProxyCacheMiss(t *testing.T) {
 	transport := new(mocks.RoundTripper)
 	defer transport.AssertExpectations(t)
 
-	response := new(http.Response)
+	response := &http.Response{StatusCode: http.StatusOK}
 	response.Body = ioutil.NopCloser(strings.NewReader("content"))
 
 	transport.
@@ -50,7 +53,7 @@ func TestProxyCacheHit(t *testing.T) {
 	cache := new(mocks.Cache)
 	defer cache.AssertExpectations(t)
 
-	response := new(http.Response)
+	response := &http.Response{StatusCode: http.StatusOK}
 	response.Body = ioutil.NopCloser(strings.NewReader("content"))
 	response.Header = http.Header{
 		"date":    []string{time.Now().Format(time.RFC1123)},
@@ -78,3 +81,199 @@ func TestProxyCacheHit(t *testing.T) {
 		t.Errorf("unexpected %q header: got %q, want %q", httpcache.XFromCache, got, want)
 	}
 }
+
+func TestProxyContentEncoding(t *testing.T) {
+	transport := new(mocks.RoundTripper)
+	defer transport.AssertExpectations(t)
+
+	body := strings.Repeat(`{"hello":"world"}`, 100)
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Date":          []string{time.Now().Format(http.TimeFormat)},
+			"Cache-Control": []string{"max-age=3600"},
+			"Content-Type":  []string{"application/json"},
+		},
+		Body: ioutil.NopCloser(strings.NewReader(body)),
+	}
+
+	transport.
+		On("RoundTrip", mock.MatchedBy(func(req *http.Request) bool {
+			return req.Header.Get("Accept-Encoding") == ""
+		})).
+		Once().
+		Return(response, nil)
+
+	cache := httpcache.NewMemoryCache()
+	p := New(WithProxyTransport(transport), WithCache(cache), WithContentEncoding("gzip", compressed.Gzip))
+	resource := "http://origin.net/resource"
+
+	for i, encoding := range []string{"gzip, deflate", "gzip;q=1", "", "br, gzip;q=0"} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/?q="+url.QueryEscape(resource), nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		p.ServeHTTP(rr, req)
+
+		if got, want := rr.HeaderMap.Get("Vary"), "Accept-Encoding"; got != want {
+			t.Errorf("request %d: unexpected Vary header: got %q, want %q", i, got, want)
+		}
+
+		got := rr.Body.String()
+		if strings.HasPrefix(encoding, "gzip") {
+			if ce := rr.HeaderMap.Get("Content-Encoding"); ce != "gzip" {
+				t.Fatalf("request %d: unexpected Content-Encoding: got %q, want %q", i, ce, "gzip")
+			}
+			r, err := gzip.NewReader(rr.Body)
+			if err != nil {
+				t.Fatalf("request %d: unexpected error: %q", i, err)
+			}
+			b, _ := ioutil.ReadAll(r)
+			got = string(b)
+		} else if ce := rr.HeaderMap.Get("Content-Encoding"); ce != "" {
+			t.Errorf("request %d: unexpected Content-Encoding: got %q, want %q", i, ce, "")
+		}
+
+		if got != body {
+			t.Errorf("request %d: unexpected body: got %q, want %q", i, got, body)
+		}
+	}
+
+	if _, ok := cache.Get(resource + " content-encoding=gzip"); !ok {
+		t.Errorf("expected gzip variant to be cached")
+	}
+}
+
+func TestProxyContentEncodingPreference(t *testing.T) {
+	body := strings.Repeat("content", 100)
+	transport := new(mocks.RoundTripper)
+	defer transport.AssertExpectations(t)
+	transport.On("RoundTrip", mock.Anything).Once().Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=60"},
+			"Date":          []string{time.Now().UTC().Format(http.TimeFormat)},
+			"Content-Type":  []string{"text/html"},
+		},
+		Body: ioutil.NopCloser(strings.NewReader(body)),
+	}, nil)
+
+	p := New(WithProxyTransport(transport), WithContentEncoding("br", compressed.Brotli), WithContentEncoding("gzip", compressed.Gzip))
+
+	tests := []struct {
+		accept string
+		want   string
+		codec  compressed.Codec
+	}{
+		{"gzip, deflate, br", "br", compressed.Brotli},
+		{"gzip", "gzip", compressed.Gzip},
+		{"br;q=0, *", "gzip", compressed.Gzip},
+		{"*", "br", compressed.Brotli},
+		{"identity", "", nil},
+	}
+
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/?q="+url.QueryEscape("http://origin.net/resource"), nil)
+		req.Header.Set("Accept-Encoding", test.accept)
+		p.ServeHTTP(rr, req)
+
+		if got := rr.HeaderMap.Get("Content-Encoding"); got != test.want {
+			t.Errorf("unexpected Content-Encoding for %q: got %q, want %q", test.accept, got, test.want)
+			continue
+		}
+		got := rr.Body.String()
+		if test.codec != nil {
+			r, err := test.codec.NewReader(rr.Body)
+			if err != nil {
+				t.Fatalf("unexpected error for %q: %s", test.accept, err)
+			}
+			b, _ := ioutil.ReadAll(r)
+			got = string(b)
+		}
+		if got != body {
+			t.Errorf("unexpected body for %q: got %q, want %q", test.accept, got, body)
+		}
+	}
+}
+
+func TestProxyContentDecoding(t *testing.T) {
+	transport := new(mocks.RoundTripper)
+	defer transport.AssertExpectations(t)
+
+	body := "content"
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	w.Write([]byte(body))
+	w.Close()
+
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type":     []string{"image/png"},
+			"Content-Encoding": []string{"gzip"},
+		},
+		Body: ioutil.NopCloser(buf),
+	}
+
+	transport.On("RoundTrip", mock.Anything).Once().Return(response, nil)
+
+	p := New(WithProxyTransport(transport), WithContentEncoding("gzip", compressed.Gzip))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?q="+url.QueryEscape("http://origin.net/resource"), nil)
+	p.ServeHTTP(rr, req)
+
+	if got, want := rr.HeaderMap.Get("Content-Encoding"), ""; got != want {
+		t.Errorf("unexpected Content-Encoding: got %q, want %q", got, want)
+	}
+
+	if got, want := rr.Body.String(), body; got != want {
+		t.Errorf("unexpected body: got %q, want %q", got, want)
+	}
+}
+
+func TestProxyVary(t *testing.T) {
+	tests := []struct {
+		max       int
+		languages []string
+		fetches   int
+	}{
+		{defaultMaxVariants, []string{"fr", "en", "fr", "en", ""}, 3},
+		{2, []string{"fr", "en", "de", "en", "fr"}, 4},
+		{1, []string{"fr", "en", "fr"}, 3},
+	}
+
+	for i, test := range tests {
+		transport := new(mocks.RoundTripper)
+		transport.
+			On("RoundTrip", mock.Anything).
+			Times(test.fetches).
+			Return(func(req *http.Request) *http.Response {
+				lang := req.Header.Get("Accept-Language")
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header: http.Header{
+						"Date":          []string{time.Now().Format(http.TimeFormat)},
+						"Cache-Control": []string{"max-age=3600"},
+						"Vary":          []string{"Accept-Language"},
+					},
+					Body: ioutil.NopCloser(strings.NewReader("content-" + lang)),
+				}
+			}, nil)
+
+		p := New(WithProxyTransport(transport), WithMaxVariants(test.max))
+
+		for _, lang := range test.languages {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/?q="+url.QueryEscape("http://origin.net/resource"), nil)
+			req.Header.Set("Accept-Language", lang)
+			p.ServeHTTP(rr, req)
+
+			if got, want := rr.Body.String(), "content-"+lang; got != want {
+				t.Errorf("test %d: unexpected body: got %q, want %q", i, got, want)
+			}
+		}
+
+		transport.AssertExpectations(t)
+	}
+}
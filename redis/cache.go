@@ -0,0 +1,214 @@
+package redis
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mikegleasonjr/getcached/freshness"
+	"github.com/mikegleasonjr/getcached/internal/remote"
+)
+
+const (
+	defaultAddr = "localhost:6379"
+	defaultTTL  = 24 * time.Hour
+)
+
+// Cache caches requests in a Redis server. Calls failing or
+// exceeding the timeout are treated as misses, and the server
+// is left alone for a while after consecutive failures. Values
+// expire once the responses they hold can't be served anymore.
+type Cache struct {
+	options remote.Options
+	ttl     time.Duration
+	pool    *remote.Pool
+}
+
+// New creates a Cache connecting to a Redis server. Connections
+// are established lazily.
+func New(options ...func(*Cache)) *Cache {
+	c := &Cache{options: remote.NewOptions(defaultAddr), ttl: defaultTTL}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	c.pool = remote.NewPool(c.options)
+
+	return c
+}
+
+// Get gets an item from the cache.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	err := c.pool.Do(func(conn *remote.Conn) error {
+		if err := command(conn, "GET", c.options.Prefix+key); err != nil {
+			return err
+		}
+		var err error
+		resp, ok, err = bulk(conn)
+		return err
+	})
+	return resp, ok && err == nil
+}
+
+// Set saves a response to the cache as key.
+func (c *Cache) Set(key string, resp []byte) {
+	args := []string{"SET", c.options.Prefix + key, string(resp)}
+	if ttl := c.expiry(resp); ttl > 0 {
+		args = append(args, "EX", strconv.FormatInt(int64(ttl/time.Second), 10))
+	}
+
+	c.pool.Do(func(conn *remote.Conn) error {
+		if err := command(conn, args...); err != nil {
+			return err
+		}
+		_, err := reply(conn)
+		return err
+	})
+}
+
+// Delete deletes an item from the cache.
+func (c *Cache) Delete(key string) {
+	c.pool.Do(func(conn *remote.Conn) error {
+		if err := command(conn, "DEL", c.options.Prefix+key); err != nil {
+			return err
+		}
+		_, err := reply(conn)
+		return err
+	})
+}
+
+// Close closes the connections to the server.
+func (c *Cache) Close() error {
+	return c.pool.Close()
+}
+
+// expiry returns the time a response can still be served,
+// even stale, in whole seconds. Responses without explicit
+// freshness, compressed ones included, and responses only
+// served once revalidated expire after the default TTL.
+func (c *Cache) expiry(resp []byte) time.Duration {
+	ttl := c.ttl
+	if f, ok := freshness.Parse(resp); ok {
+		if until := time.Until(f.StaleUntil()); until > 0 {
+			ttl = until
+		}
+	}
+	return (ttl + time.Second - 1) / time.Second * time.Second
+}
+
+// command writes a command as an array of bulk strings.
+func command(conn *remote.Conn, args ...string) error {
+	fmt.Fprintf(conn.W, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(conn.W, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return conn.W.Flush()
+}
+
+// reply reads a simple string, error or integer reply.
+func reply(conn *remote.Conn) (string, error) {
+	line, err := conn.R.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") || len(line) < 3 {
+		return "", fmt.Errorf("malformed reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+', ':', '$':
+		return line[1:], nil
+	case '-':
+		return "", remote.ReplyError(line[1:])
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
+
+// bulk reads a bulk string reply, which is not ok when nil.
+func bulk(conn *remote.Conn) ([]byte, bool, error) {
+	line, err := reply(conn)
+	if err != nil {
+		return nil, false, err
+	}
+
+	n, err := strconv.Atoi(line)
+	if err != nil {
+		return nil, false, fmt.Errorf("malformed bulk length %q", line)
+	}
+	if n < 0 {
+		return nil, false, nil
+	}
+
+	b := make([]byte, n+2)
+	if _, err := io.ReadFull(conn.R, b); err != nil {
+		return nil, false, err
+	}
+	if string(b[n:]) != "\r\n" {
+		return nil, false, fmt.Errorf("malformed bulk string")
+	}
+
+	return b[:n], true, nil
+}
+
+// WithAddr sets the address of the server.
+func WithAddr(addr string) func(*Cache) {
+	return func(c *Cache) {
+		c.options.Addr = addr
+	}
+}
+
+// WithPrefix sets a prefix added to the keys, to share a
+// server with other applications.
+func WithPrefix(prefix string) func(*Cache) {
+	return func(c *Cache) {
+		c.options.Prefix = prefix
+	}
+}
+
+// WithTimeout sets the max duration getting a connection to
+// the server, after which a call is treated as a miss.
+func WithTimeout(timeout time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.options.Timeout = timeout
+	}
+}
+
+// WithIOTimeout sets the max duration the server can take to
+// accept or send the next bytes of a call, after which the call
+// is treated as a miss. Calls transferring large values are not
+// bounded as long as the server makes progress.
+func WithIOTimeout(timeout time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.options.IOTimeout = timeout
+	}
+}
+
+// WithPoolSize sets the max number of connections.
+func WithPoolSize(size int) func(*Cache) {
+	return func(c *Cache) {
+		c.options.PoolSize = size
+	}
+}
+
+// WithBreaker configures the number of consecutive failures
+// after which the server is not called for a cooldown period.
+// A threshold of 0 disables the breaker.
+func WithBreaker(threshold int, cooldown time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.options.Threshold = threshold
+		c.options.Cooldown = cooldown
+	}
+}
+
+// WithTTL sets the time values are kept when the responses they
+// hold have no explicit freshness or can only be served once
+// revalidated. A TTL of 0 keeps them until the server evicts them.
+func WithTTL(ttl time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
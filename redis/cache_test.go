@@ -0,0 +1,255 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/cachetest"
+)
+
+func TestConformance(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	cachetest.RunConformance(t, func() httpcache.Cache { return New(WithAddr(s.Addr()), WithTimeout(time.Second)) })
+}
+
+func TestPrefix(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := New(WithAddr(s.Addr()), WithPrefix("getcached:"))
+	defer c.Close()
+
+	c.Set("key", []byte("value"))
+	if got := s.value("getcached:key"); got != "value" {
+		t.Errorf("unexpected stored value: got %q, want %q", got, "value")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	date := time.Now().UTC().Format(http.TimeFormat)
+	fresh := "HTTP/1.1 200 OK\r\nDate: " + date + "\r\nCache-Control: max-age=60, stale-if-error=30\r\n\r\n"
+	stale := "HTTP/1.1 200 OK\r\nDate: " + date + "\r\nCache-Control: no-cache\r\nEtag: \"1\"\r\n\r\n"
+
+	tests := []struct {
+		name    string
+		options []func(*Cache)
+		value   string
+		ttls    []string
+	}{
+		{"fresh", nil, fresh, []string{"89", "90"}},
+		{"stale", nil, stale, []string{"86400"}},
+		{"unknown", nil, "\x00gcz\x01\x03compressed", []string{"86400"}},
+		{"default", []func(*Cache){WithTTL(time.Minute)}, "value", []string{"60"}},
+		{"none", []func(*Cache){WithTTL(0)}, "value", []string{""}},
+	}
+
+	for _, test := range tests {
+		c := New(append([]func(*Cache){WithAddr(s.Addr())}, test.options...)...)
+		c.Set(test.name, []byte(test.value))
+		c.Close()
+
+		got := s.ttl(test.name)
+		if got != test.ttls[0] && got != test.ttls[len(test.ttls)-1] {
+			t.Errorf("unexpected ttl of %s: got %q, want %q", test.name, got, test.ttls)
+		}
+	}
+}
+
+func TestSlowServer(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := New(WithAddr(s.Addr()), WithIOTimeout(50*time.Millisecond), WithBreaker(2, time.Hour))
+	defer c.Close()
+
+	c.Set("key", []byte("value"))
+	s.stall(true)
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if _, ok := c.Get("key"); ok {
+			t.Errorf("unexpected hit from a stalled server")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("unexpected call duration: got %v, want < %v", elapsed, time.Second)
+		}
+	}
+
+	s.stall(false)
+	calls := s.calls()
+	if _, ok := c.Get("key"); ok {
+		t.Errorf("unexpected hit with an open circuit")
+	}
+	if got := s.calls(); got != calls {
+		t.Errorf("unexpected calls with an open circuit: got %d, want %d", got, calls)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := New(WithAddr(s.Addr()), WithIOTimeout(50*time.Millisecond), WithBreaker(1, 10*time.Millisecond))
+	defer c.Close()
+
+	s.stall(true)
+	c.Set("key", []byte("value"))
+	s.stall(false)
+
+	time.Sleep(20 * time.Millisecond)
+	c.Set("key", []byte("value"))
+	if got, ok := c.Get("key"); !ok || string(got) != "value" {
+		t.Errorf("unexpected value after recovery: got %q (%t), want %q", got, ok, "value")
+	}
+}
+
+// fakeServer is an in-process server speaking enough of the
+// RESP protocol for Cache.
+type fakeServer struct {
+	l       net.Listener
+	mu      sync.Mutex
+	values  map[string]string
+	ttls    map[string]string // EX of the values, in seconds
+	stalled int32
+	n       int32
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	s := &fakeServer{l: l, values: map[string]string{}, ttls: map[string]string{}}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string { return s.l.Addr().String() }
+func (s *fakeServer) Close() error { return s.l.Close() }
+
+func (s *fakeServer) stall(stalled bool) {
+	var v int32
+	if stalled {
+		v = 1
+	}
+	atomic.StoreInt32(&s.stalled, v)
+}
+
+func (s *fakeServer) calls() int32 { return atomic.LoadInt32(&s.n) }
+
+func (s *fakeServer) value(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+func (s *fakeServer) ttl(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ttls[key]
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&s.n, 1)
+		if atomic.LoadInt32(&s.stalled) == 1 {
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+
+		s.mu.Lock()
+		switch {
+		case args[0] == "GET" && len(args) == 2:
+			if v, ok := s.values[args[1]]; ok {
+				fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				w.WriteString("$-1\r\n")
+			}
+		case args[0] == "SET" && (len(args) == 3 || len(args) == 5 && args[3] == "EX"):
+			s.values[args[1]] = args[2]
+			s.ttls[args[1]] = ""
+			if len(args) == 5 {
+				s.ttls[args[1]] = args[4]
+			}
+			w.WriteString("+OK\r\n")
+		case args[0] == "DEL" && len(args) == 2:
+			_, ok := s.values[args[1]]
+			delete(s.values, args[1])
+			if ok {
+				w.WriteString(":1\r\n")
+			} else {
+				w.WriteString(":0\r\n")
+			}
+		default:
+			fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		s.mu.Unlock()
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readLength(r, '*')
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		l, err := readLength(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, l+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:l])
+	}
+
+	if n == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+func readLength(r *bufio.Reader, prefix byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	if len(line) < 3 || line[0] != prefix {
+		return 0, fmt.Errorf("unexpected line %q", line)
+	}
+	return strconv.Atoi(line[1 : len(line)-2])
+}
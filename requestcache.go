@@ -0,0 +1,67 @@
+package getcached
+
+import (
+	"context"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/trace"
+)
+
+// requestCache is the httpcache.Cache seen by httpcache for a
+// single request. It records the tier serving the request in
+// its Exchange and, if traced, a span per cache operation.
+type requestCache struct {
+	c   httpcache.Cache
+	ctx context.Context
+	e   *Exchange
+}
+
+// cacheFor returns c as seen by the request of ctx.
+func cacheFor(ctx context.Context, c httpcache.Cache) httpcache.Cache {
+	e := ExchangeFrom(ctx)
+	if e == nil && trace.FromContext(ctx) == nil {
+		return c
+	}
+	return &requestCache{c: c, ctx: ctx, e: e}
+}
+
+// Get implements httpcache.Cache.
+func (r *requestCache) Get(key string) (b []byte, ok bool) {
+	_, span := trace.StartChild(r.ctx, "cache.get", trace.Internal)
+
+	var tier string
+	if l, isLocator := r.c.(Locator); isLocator {
+		b, tier, ok = l.Locate(key)
+	} else {
+		b, ok = r.c.Get(key)
+	}
+	if ok && r.e != nil {
+		r.e.Tier = tier
+	}
+
+	span.SetAttribute("cache.key", key)
+	span.SetAttribute("cache.hit", ok)
+	span.SetAttribute("cache.size", len(b))
+	if tier != "" {
+		span.SetAttribute("cache.tier", tier)
+	}
+	span.Finish(nil)
+	return b, ok
+}
+
+// Set implements httpcache.Cache.
+func (r *requestCache) Set(key string, resp []byte) {
+	_, span := trace.StartChild(r.ctx, "cache.set", trace.Internal)
+	r.c.Set(key, resp)
+	span.SetAttribute("cache.key", key)
+	span.SetAttribute("cache.size", len(resp))
+	span.Finish(nil)
+}
+
+// Delete implements httpcache.Cache.
+func (r *requestCache) Delete(key string) {
+	_, span := trace.StartChild(r.ctx, "cache.delete", trace.Internal)
+	r.c.Delete(key)
+	span.SetAttribute("cache.key", key)
+	span.Finish(nil)
+}
@@ -0,0 +1,251 @@
+package getcached
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxHosts = 100
+
+	// OtherHosts is the origin host under which a RequestMonitor
+	// counts the hosts beyond its limit.
+	OtherHosts = "other"
+)
+
+// RequestStats are request statistics returned from a
+// RequestMonitor.
+type RequestStats struct {
+	Requests  int64                        // total requests
+	Codes     map[int]int64                // requests by status code
+	Durations map[CacheStatus]Distribution // durations of the requests by cache status (in nanoseconds)
+	Origins   map[string]OriginStats       // statistics by origin host
+}
+
+// OriginStats are the statistics of an origin host.
+type OriginStats struct {
+	Requests int64        // requests for the origin
+	Fetches  int64        // requests made to the origin
+	Errors   int64        // requests to the origin failing
+	Latency  Distribution // time to the origin response headers (in nanoseconds)
+}
+
+type originStats struct {
+	requests AtomicInt
+	fetches  AtomicInt
+	errors   AtomicInt
+	latency  *Histogram
+}
+
+// RequestMonitor is an http.Handler decorator which keeps
+// track of statistics about the requests served by a Proxy.
+type RequestMonitor struct {
+	h        http.Handler
+	buckets  []int64
+	maxHosts int
+	requests AtomicInt
+
+	mu        sync.RWMutex // guards the maps
+	codes     map[int]*AtomicInt
+	durations map[CacheStatus]*Histogram
+	origins   map[string]*originStats
+}
+
+// NewRequestMonitor creates a RequestMonitor.
+func NewRequestMonitor(h http.Handler, options ...func(*RequestMonitor)) *RequestMonitor {
+	m := &RequestMonitor{
+		h:         h,
+		buckets:   DefaultLatencyBuckets,
+		maxHosts:  defaultMaxHosts,
+		codes:     map[int]*AtomicInt{},
+		durations: map[CacheStatus]*Histogram{},
+		origins:   map[string]*originStats{},
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+// WithMaxHosts configures the max number of origin hosts a
+// RequestMonitor keeps statistics for. Requests for further
+// hosts are counted under OtherHosts.
+func WithMaxHosts(n int) func(*RequestMonitor) {
+	return func(m *RequestMonitor) {
+		m.maxHosts = n
+	}
+}
+
+// WithRequestBuckets configures the upper bounds of the
+// latency histograms of a RequestMonitor.
+func WithRequestBuckets(bounds ...time.Duration) func(*RequestMonitor) {
+	return func(m *RequestMonitor) {
+		m.buckets = make([]int64, len(bounds))
+		for i, b := range bounds {
+			m.buckets[i] = int64(b)
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (m *RequestMonitor) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	req, e := WithExchange(req)
+	w := &responseRecorder{ResponseWriter: rw}
+
+	m.h.ServeHTTP(w, req)
+
+	m.requests.Add(1)
+	m.code(w.Status()).Add(1)
+	m.duration(e.CacheStatus()).Observe(int64(time.Since(start)))
+
+	if e.Origin == nil {
+		return
+	}
+	o := m.origin(e.Origin.Host)
+	o.requests.Add(1)
+	if e.Fetches > 0 {
+		o.fetches.Add(int64(e.Fetches))
+		o.latency.Observe(int64(e.OriginLatency))
+		if e.OriginErr != nil || e.OriginStatus >= http.StatusInternalServerError {
+			o.errors.Add(1)
+		}
+	}
+}
+
+// Stats returns the current request stats.
+func (m *RequestMonitor) Stats() *RequestStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := &RequestStats{
+		Requests:  m.requests.Get(),
+		Codes:     make(map[int]int64, len(m.codes)),
+		Durations: make(map[CacheStatus]Distribution, len(m.durations)),
+		Origins:   make(map[string]OriginStats, len(m.origins)),
+	}
+	for code, n := range m.codes {
+		s.Codes[code] = n.Get()
+	}
+	for status, h := range m.durations {
+		s.Durations[status] = h.Distribution()
+	}
+	for host, o := range m.origins {
+		s.Origins[host] = OriginStats{
+			Requests: o.requests.Get(),
+			Fetches:  o.fetches.Get(),
+			Errors:   o.errors.Get(),
+			Latency:  o.latency.Distribution(),
+		}
+	}
+
+	return s
+}
+
+func (m *RequestMonitor) code(code int) *AtomicInt {
+	m.mu.RLock()
+	n, ok := m.codes[code]
+	m.mu.RUnlock()
+	if ok {
+		return n
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n, ok = m.codes[code]; !ok {
+		n = new(AtomicInt)
+		m.codes[code] = n
+	}
+	return n
+}
+
+func (m *RequestMonitor) duration(status CacheStatus) *Histogram {
+	m.mu.RLock()
+	h, ok := m.durations[status]
+	m.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok = m.durations[status]; !ok {
+		h = NewHistogram(m.buckets...)
+		m.durations[status] = h
+	}
+	return h
+}
+
+// origin returns the statistics of a host, or of OtherHosts
+// once the max number of hosts is reached.
+func (m *RequestMonitor) origin(host string) *originStats {
+	m.mu.RLock()
+	o, ok := m.origins[host]
+	m.mu.RUnlock()
+	if ok {
+		return o
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if o, ok = m.origins[host]; ok {
+		return o
+	}
+	if len(m.origins) >= m.maxHosts {
+		host = OtherHosts
+		if o, ok = m.origins[host]; ok {
+			return o
+		}
+	}
+	o = &originStats{latency: NewHistogram(m.buckets...)}
+	m.origins[host] = o
+	return o
+}
+
+// responseRecorder is an http.ResponseWriter recording the
+// status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	before func() // called before writing the header
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		if r.before != nil {
+			r.before()
+		}
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Status returns the status of the response.
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
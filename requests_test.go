@@ -0,0 +1,74 @@
+package getcached
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRequestMonitor(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/error" {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Cache-Control", "max-age=3600")
+		rw.Write([]byte("content"))
+	}))
+	defer origin.Close()
+
+	m := NewRequestMonitor(New())
+	for _, path := range []string{"/resource", "/resource", "/error"} {
+		req := httptest.NewRequest("GET", "/?q="+url.QueryEscape(origin.URL+path), nil)
+		m.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	s := m.Stats()
+	if got, want := s.Requests, int64(4); got != want {
+		t.Errorf("unexpected requests: got %d, want %d", got, want)
+	}
+	if diff := cmp.Diff(map[int]int64{200: 2, 500: 1, 502: 1}, s.Codes); diff != "" {
+		t.Errorf("codes mismatch (-want +got):\n%s", diff)
+	}
+
+	counts := map[CacheStatus]int64{}
+	for status, d := range s.Durations {
+		counts[status] = d.Count
+	}
+	if diff := cmp.Diff(map[CacheStatus]int64{StatusHit: 1, StatusMiss: 3}, counts); diff != "" {
+		t.Errorf("cache statuses mismatch (-want +got):\n%s", diff)
+	}
+
+	host := origin.Listener.Addr().String()
+	o := s.Origins[host]
+	if o.Requests != 3 || o.Fetches != 2 || o.Errors != 1 || o.Latency.Count != 2 {
+		t.Errorf("unexpected stats for %s: got %d requests, %d fetches, %d errors, %d latencies, want %d, %d, %d, %d",
+			host, o.Requests, o.Fetches, o.Errors, o.Latency.Count, 3, 2, 1, 2)
+	}
+}
+
+func TestRequestMonitorMaxHosts(t *testing.T) {
+	h := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, e := WithExchange(req)
+		e.Origin, _ = url.Parse(req.URL.Query().Get("q"))
+	})
+	m := NewRequestMonitor(h, WithMaxHosts(2))
+
+	for i := 0; i < 5; i++ {
+		u := fmt.Sprintf("http://host%d.net/", i)
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?q="+url.QueryEscape(u), nil))
+	}
+
+	requests := map[string]int64{}
+	for host, o := range m.Stats().Origins {
+		requests[host] = o.Requests
+	}
+	if diff := cmp.Diff(map[string]int64{"host0.net": 1, "host1.net": 1, OtherHosts: 3}, requests); diff != "" {
+		t.Errorf("origins mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,463 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikegleasonjr/getcached/internal/remote"
+)
+
+const (
+	defaultEndpoint  = "https://s3.amazonaws.com"
+	defaultRegion    = "us-east-1"
+	defaultPartSize  = 16 << 20 // 16MB
+	defaultTimeout   = time.Second
+	defaultQueueSize = 256
+	writers          = 4
+)
+
+// Cache caches requests as objects of an S3 compatible bucket,
+// addressed in path style. Objects larger than the part size
+// are uploaded in parts. Failed calls are treated as misses,
+// and the service is left alone for a while after consecutive
+// failures.
+//
+// Objects are written in the background: Set and Delete return
+// once the write is queued, and Get returns the values queued.
+// Values are dropped when the queue is full, while deletions are
+// then made right away. Close waits for the queued writes.
+type Cache struct {
+	endpoint  string
+	bucket    string
+	prefix    string
+	partSize  int
+	timeout   time.Duration
+	threshold int
+	cooldown  time.Duration
+	queueSize int
+	creds     credentials
+	client    *http.Client
+	breaker   *remote.Breaker
+	mu        sync.Mutex        // guards the fields below
+	pending   map[string]*write // latest write queued by key
+	queue     chan string
+	closed    bool
+	writers   sync.WaitGroup
+}
+
+type write struct {
+	resp    []byte
+	deleted bool
+}
+
+// New creates a Cache storing objects in a bucket.
+func New(options ...func(*Cache)) *Cache {
+	c := &Cache{
+		endpoint:  defaultEndpoint,
+		partSize:  defaultPartSize,
+		timeout:   defaultTimeout,
+		threshold: remote.DefaultThreshold,
+		cooldown:  remote.DefaultCooldown,
+		queueSize: defaultQueueSize,
+		creds:     credentials{region: defaultRegion},
+		client:    &http.Client{},
+		pending:   map[string]*write{},
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	c.endpoint = strings.TrimSuffix(c.endpoint, "/")
+	c.breaker = remote.NewBreaker(c.threshold, c.cooldown)
+	c.queue = make(chan string, c.queueSize)
+
+	c.writers.Add(writers)
+	for i := 0; i < writers; i++ {
+		go c.writer()
+	}
+
+	return c
+}
+
+// Get gets an item from the cache.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	w, ok := c.pending[key]
+	c.mu.Unlock()
+	if ok {
+		return w.resp, !w.deleted
+	}
+
+	res, err := c.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, false
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, false
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, false
+	}
+
+	return b, true
+}
+
+// Set saves a response to the cache as key.
+func (c *Cache) Set(key string, resp []byte) {
+	c.enqueue(key, &write{resp: resp})
+}
+
+// Delete deletes an item from the cache.
+func (c *Cache) Delete(key string) {
+	w := &write{deleted: true}
+	if !c.enqueue(key, w) {
+		c.write(key, w)
+	}
+}
+
+// Close waits for the queued writes. Writes are not queued
+// anymore once closed.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.mu.Unlock()
+
+	c.writers.Wait()
+	return nil
+}
+
+// enqueue queues a write, replacing the write of the key still
+// queued if any. It returns false if the queue is full or closed.
+func (c *Cache) enqueue(key string, w *write) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	if _, ok := c.pending[key]; ok {
+		c.pending[key] = w
+		return true
+	}
+
+	select {
+	case c.queue <- key:
+		c.pending[key] = w
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Cache) writer() {
+	defer c.writers.Done()
+
+	for key := range c.queue {
+		for {
+			c.mu.Lock()
+			w := c.pending[key]
+			c.mu.Unlock()
+
+			c.write(key, w)
+
+			// the key is queued once, until its latest write is made
+			c.mu.Lock()
+			done := c.pending[key] == w
+			if done {
+				delete(c.pending, key)
+			}
+			c.mu.Unlock()
+			if done {
+				break
+			}
+		}
+	}
+}
+
+func (c *Cache) write(key string, w *write) {
+	switch {
+	case w.deleted:
+		if res, err := c.do(http.MethodDelete, key, nil, nil); err == nil {
+			drain(res)
+		}
+	case len(w.resp) > c.partSize:
+		c.upload(key, w.resp)
+	default:
+		if res, err := c.do(http.MethodPut, key, nil, w.resp); err == nil {
+			drain(res)
+		}
+	}
+}
+
+type completeUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []part   `xml:"Part"`
+}
+
+type part struct {
+	PartNumber int
+	ETag       string
+}
+
+// upload uploads a value in parts, aborting the upload if a
+// part fails.
+func (c *Cache) upload(key string, resp []byte) {
+	res, err := c.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(res.Body).Decode(&initiated)
+	drain(res)
+	if err != nil || res.StatusCode != http.StatusOK || initiated.UploadID == "" {
+		return
+	}
+
+	upload := url.Values{"uploadId": {initiated.UploadID}}
+	complete := completeUpload{}
+
+	for n := 1; len(resp) > 0; n++ {
+		size := c.partSize
+		if size > len(resp) {
+			size = len(resp)
+		}
+
+		query := url.Values{"uploadId": {initiated.UploadID}, "partNumber": {strconv.Itoa(n)}}
+		res, err := c.do(http.MethodPut, key, query, resp[:size])
+		if err != nil || res.StatusCode != http.StatusOK {
+			if err == nil {
+				drain(res)
+			}
+			c.abort(key, upload)
+			return
+		}
+		drain(res)
+
+		complete.Parts = append(complete.Parts, part{PartNumber: n, ETag: res.Header.Get("ETag")})
+		resp = resp[size:]
+	}
+
+	body, _ := xml.Marshal(complete)
+	res, err = c.do(http.MethodPost, key, upload, body)
+	if err != nil {
+		c.abort(key, upload)
+		return
+	}
+
+	// completion errors may come with a 200 status
+	reply, err := ioutil.ReadAll(res.Body)
+	drain(res)
+	if err != nil || res.StatusCode != http.StatusOK || bytes.Contains(reply, []byte("<Error>")) {
+		c.abort(key, upload)
+	}
+}
+
+func (c *Cache) abort(key string, upload url.Values) {
+	if res, err := c.do(http.MethodDelete, key, upload, nil); err == nil {
+		drain(res)
+	}
+}
+
+// do sends a signed request for the object of a key. The
+// request is canceled once the service made no progress for
+// the timeout, sending the request or reading the response.
+func (c *Cache) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	if !c.breaker.Allow() {
+		return nil, remote.ErrOpen
+	}
+
+	u, err := url.Parse(c.endpoint)
+	if err != nil {
+		c.breaker.Skip()
+		return nil, err
+	}
+	u.Path = u.Path + "/" + c.bucket + "/" + c.object(key)
+	u.RawPath = escape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &deadline{timer: time.AfterFunc(c.timeout, cancel), timeout: c.timeout, cancel: cancel}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		d.release()
+		c.breaker.Skip()
+		return nil, err
+	}
+	if len(body) > 0 {
+		req.Body = ioutil.NopCloser(&progress{Reader: bytes.NewReader(body), d: d})
+		req.ContentLength = int64(len(body))
+	}
+	c.creds.sign(req, hexSHA256(body), time.Now())
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		d.release()
+		c.breaker.Failure()
+		return nil, err
+	}
+	if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests {
+		c.breaker.Failure()
+	} else {
+		c.breaker.Success()
+	}
+
+	res.Body = &progress{Reader: res.Body, Closer: res.Body, d: d}
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotFound {
+		drain(res)
+		return nil, fmt.Errorf("unexpected status %q", res.Status)
+	}
+	return res, nil
+}
+
+// deadline cancels a request once it made no progress for
+// a timeout.
+type deadline struct {
+	timer   *time.Timer
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+func (d *deadline) extend() {
+	d.timer.Reset(d.timeout)
+}
+
+func (d *deadline) release() {
+	d.timer.Stop()
+	d.cancel()
+}
+
+// progress is a request or response body extending the
+// deadline of the request whenever bytes are read.
+type progress struct {
+	io.Reader
+	io.Closer // of response bodies, releasing the deadline
+	d         *deadline
+}
+
+func (p *progress) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	if n > 0 {
+		p.d.extend()
+	}
+	return n, err
+}
+
+func (p *progress) Close() error {
+	err := p.Closer.Close()
+	p.d.release()
+	return err
+}
+
+// object hashes a key into an object name, as keys are URLs
+// possibly longer than allowed.
+func (c *Cache) object(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return c.prefix + hex.EncodeToString(sum[:])
+}
+
+func drain(res *http.Response) {
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+// WithEndpoint sets the URL of the S3 service.
+func WithEndpoint(endpoint string) func(*Cache) {
+	return func(c *Cache) {
+		c.endpoint = endpoint
+	}
+}
+
+// WithBucket sets the bucket objects are stored in.
+func WithBucket(bucket string) func(*Cache) {
+	return func(c *Cache) {
+		c.bucket = bucket
+	}
+}
+
+// WithRegion sets the region requests are signed for.
+func WithRegion(region string) func(*Cache) {
+	return func(c *Cache) {
+		c.creds.region = region
+	}
+}
+
+// WithCredentials sets the access keys requests are signed with.
+func WithCredentials(accessKey, secretKey string) func(*Cache) {
+	return func(c *Cache) {
+		c.creds.accessKey = accessKey
+		c.creds.secretKey = secretKey
+	}
+}
+
+// WithPrefix sets a prefix added to the object names.
+func WithPrefix(prefix string) func(*Cache) {
+	return func(c *Cache) {
+		c.prefix = prefix
+	}
+}
+
+// WithPartSize sets the size (in bytes) above which objects
+// are uploaded in parts of that size. S3 requires parts of
+// at least 5MB.
+func WithPartSize(size int) func(*Cache) {
+	return func(c *Cache) {
+		c.partSize = size
+	}
+}
+
+// WithTimeout sets the max duration the service can take to
+// answer a call, or to accept or send the next bytes of the
+// objects, after which the call fails and is treated as a miss.
+func WithTimeout(timeout time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.timeout = timeout
+	}
+}
+
+// WithBreaker configures the number of consecutive failures
+// after which the service is not called for a cooldown period.
+// A threshold of 0 disables the breaker.
+func WithBreaker(threshold int, cooldown time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.threshold = threshold
+		c.cooldown = cooldown
+	}
+}
+
+// WithQueueSize sets the max number of keys with writes queued.
+func WithQueueSize(size int) func(*Cache) {
+	return func(c *Cache) {
+		c.queueSize = size
+	}
+}
+
+// WithClient sets the http.Client used to call the service.
+func WithClient(client *http.Client) func(*Cache) {
+	return func(c *Cache) {
+		c.client = client
+	}
+}
@@ -0,0 +1,198 @@
+// Package snapshot exports the entries of a cache to an
+// archive and imports them back, to move or seed a cache
+// without refetching everything from the origins.
+//
+// Archives are tar files in the PAX format. Each entry is a
+// regular file holding a response serialized as stored by
+// httpcache. Files are named after the SHA-256 of their key,
+// which is kept in the GETCACHED.key PAX record. Entries are
+// archived from the least to the most recently used, so that
+// caches with a smaller capacity keep the most recently used
+// ones when importing.
+package snapshot
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached"
+	"github.com/mikegleasonjr/getcached/freshness"
+)
+
+const keyRecord = "GETCACHED.key"
+
+// ErrNotRanger is returned when exporting a cache whose
+// entries can't be enumerated.
+var ErrNotRanger = errors.New("cache entries can't be enumerated")
+
+// Ranger is implemented by caches enumerating their entries,
+// such as lru.Cache.
+type Ranger interface {
+	Range(fn func(key string, size int64))
+}
+
+// Stats counts the entries of an import.
+type Stats struct {
+	Imported int `json:"imported"` // entries stored in the cache
+	Expired  int `json:"expired"`  // entries skipped as past their stale window
+	Invalid  int `json:"invalid"`  // entries skipped as lacking a key
+}
+
+// Export writes the entries of c to w as an archive and
+// returns how many were written.
+func Export(w io.Writer, c httpcache.Cache) (int, error) {
+	src, r := ranger(c)
+	if r == nil {
+		return 0, ErrNotRanger
+	}
+
+	keys := []string{}
+	r.Range(func(key string, _ int64) {
+		keys = append(keys, key)
+	})
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	n := 0
+
+	for _, key := range keys {
+		value, ok := src.Get(key)
+		if !ok {
+			continue // evicted meanwhile
+		}
+
+		sum := sha256.Sum256([]byte(key))
+		hdr := &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       hex.EncodeToString(sum[:]),
+			Mode:       0644,
+			Size:       int64(len(value)),
+			ModTime:    now,
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{keyRecord: key},
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return n, err
+		}
+		if _, err := tw.Write(value); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, tw.Close()
+}
+
+// Import stores the entries of an archive in c, skipping
+// the entries which can't be served anymore.
+func Import(r io.Reader, c httpcache.Cache) (Stats, error) {
+	var stats Stats
+	tr := tar.NewReader(r)
+	now := time.Now()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		key := hdr.PAXRecords[keyRecord]
+		if key == "" {
+			stats.Invalid++
+			continue
+		}
+
+		value, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return stats, err
+		}
+
+		if f, ok := freshness.Parse(value); ok && !f.StaleUntil().After(now) {
+			stats.Expired++
+			continue
+		}
+
+		c.Set(key, value)
+		stats.Imported++
+	}
+}
+
+// handler configures the http.Handler returned by Handler.
+type handler struct {
+	log *log.Logger
+}
+
+// Handler exports the entries of c on GET requests and
+// imports the archive sent with POST or PUT requests,
+// replying with the Stats of the import. An export failing
+// once started is logged and its connection aborted, so that
+// clients don't take a truncated archive for a whole one.
+func Handler(c httpcache.Cache, options ...func(*handler)) http.Handler {
+	h := &handler{log: log.New(os.Stderr, "", log.LstdFlags)}
+
+	for _, option := range options {
+		option(h)
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			if _, r := ranger(c); r == nil {
+				http.Error(rw, ErrNotRanger.Error(), http.StatusNotImplemented)
+				return
+			}
+			rw.Header().Set("Content-Type", "application/x-tar")
+			if n, err := Export(rw, c); err != nil {
+				h.log.Printf("snapshot: export failed after %d entries: %s", n, err)
+				panic(http.ErrAbortHandler)
+			}
+		case http.MethodPost, http.MethodPut:
+			stats, err := Import(req.Body, c)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(rw).Encode(stats)
+		default:
+			rw.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// WithErrorLogger configures a Handler to log failed
+// exports to l instead of the standard error.
+func WithErrorLogger(l *log.Logger) func(*handler) {
+	return func(h *handler) {
+		h.log = l
+	}
+}
+
+// ranger returns the first cache of a chain of decorators
+// which enumerates its entries, unwrapped through
+// getcached.Wrapper.
+func ranger(c httpcache.Cache) (httpcache.Cache, Ranger) {
+	c = getcached.Find(c, func(c httpcache.Cache) bool {
+		_, ok := c.(Ranger)
+		return ok
+	})
+	r, _ := c.(Ranger)
+	return c, r
+}
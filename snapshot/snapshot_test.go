@@ -0,0 +1,175 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached"
+	"github.com/mikegleasonjr/getcached/lru"
+)
+
+func TestExportImport(t *testing.T) {
+	src := lru.New(lru.WithCache(httpcache.NewMemoryCache()))
+	fresh1 := response(time.Now(), time.Hour)
+	fresh2 := response(time.Now(), 2*time.Hour)
+	expired := response(time.Now().Add(-2*time.Hour), time.Hour)
+	src.Set("key1", fresh1)
+	src.Set("key2", expired)
+	src.Set("key3", fresh2)
+
+	buf := new(bytes.Buffer)
+	n, err := Export(buf, getcached.NewMonitor(src))
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if n != 3 {
+		t.Errorf("unexpected exported count: got %d, want %d", n, 3)
+	}
+
+	// room for a single entry
+	dst := lru.New(lru.WithCache(httpcache.NewMemoryCache()), lru.WithSize(uint64(len(fresh2))))
+	stats, err := Import(bytes.NewReader(buf.Bytes()), dst)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if diff := cmp.Diff(Stats{Imported: 2, Expired: 1}, stats); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+
+	if _, ok := dst.Get("key1"); ok {
+		t.Errorf("unexpected value for %q beyond capacity", "key1")
+	}
+	if got, ok := dst.Get("key3"); !ok || !bytes.Equal(got, fresh2) {
+		t.Errorf("value mismatch for %q: got %q, want %q", "key3", got, fresh2)
+	}
+}
+
+func TestExportNotRanger(t *testing.T) {
+	if _, err := Export(new(bytes.Buffer), httpcache.NewMemoryCache()); err != ErrNotRanger {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrNotRanger)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "nokey", Mode: 0644, Size: 5})
+	tw.Write([]byte("value"))
+	tw.Close()
+
+	stats, err := Import(buf, httpcache.NewMemoryCache())
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if diff := cmp.Diff(Stats{Invalid: 1}, stats); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+
+	if _, err := Import(bytes.NewReader([]byte("not an archive")), httpcache.NewMemoryCache()); err == nil {
+		t.Errorf("unexpected success importing garbage")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	src := lru.New()
+	value := response(time.Now(), time.Hour)
+	src.Set("key", value)
+	dst := lru.New()
+
+	srcSrv := httptest.NewServer(Handler(src))
+	defer srcSrv.Close()
+	dstSrv := httptest.NewServer(Handler(dst))
+	defer dstSrv.Close()
+
+	res, err := http.Get(srcSrv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer res.Body.Close()
+	if got, want := res.Header.Get("Content-Type"), "application/x-tar"; got != want {
+		t.Errorf("unexpected content type: got %q, want %q", got, want)
+	}
+
+	res, err = http.Post(dstSrv.URL, "application/x-tar", res.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer res.Body.Close()
+
+	var stats Stats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if diff := cmp.Diff(Stats{Imported: 1}, stats); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+	if got, ok := dst.Get("key"); !ok || !bytes.Equal(got, value) {
+		t.Errorf("value mismatch for %q: got %q, want %q", "key", got, value)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srcSrv.URL, nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	res.Body.Close()
+	if got, want := res.StatusCode, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("unexpected status: got %d, want %d", got, want)
+	}
+}
+
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestHandlerExportFailure(t *testing.T) {
+	src := lru.New()
+	src.Set("key", response(time.Now(), time.Hour))
+
+	var logs bytes.Buffer
+	h := Handler(src, WithErrorLogger(log.New(&logs, "", 0)))
+
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Errorf("unexpected panic: got %v, want %v", got, http.ErrAbortHandler)
+		}
+		if got, want := logs.String(), "snapshot: export failed after 0 entries: connection reset\n"; got != want {
+			t.Errorf("unexpected log: got %q, want %q", got, want)
+		}
+	}()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	h.ServeHTTP(failingWriter{httptest.NewRecorder()}, req)
+}
+
+func response(date time.Time, maxAge time.Duration) []byte {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Date":          {date.UTC().Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=" + strconv.Itoa(int(maxAge.Seconds()))},
+		},
+		Body:          httptest.NewRecorder().Result().Body,
+		ContentLength: 0,
+	}
+	b, _ := httputil.DumpResponse(res, true)
+	return b
+}
@@ -0,0 +1,61 @@
+package getcached
+
+import (
+	"github.com/gregjones/httpcache"
+)
+
+// Tier is a named cache of Tiers.
+type Tier struct {
+	Name  string
+	Cache httpcache.Cache
+}
+
+// Locator is implemented by caches made of tiers, such as
+// Tiers, telling which tier holds a value.
+type Locator interface {
+	Locate(key string) (resp []byte, tier string, ok bool)
+}
+
+// Tiers is a cache made of tiers, from the fastest to the
+// slowest. Values set are stored in the slowest tier and
+// removed from the faster ones, which get them back as they
+// are read: values found in a tier are copied to all the
+// faster tiers. Values deleted are so in every tier.
+type Tiers []Tier
+
+// Get implements httpcache.Cache.
+func (t Tiers) Get(key string) ([]byte, bool) {
+	b, _, ok := t.Locate(key)
+	return b, ok
+}
+
+// Locate implements Locator.
+func (t Tiers) Locate(key string) ([]byte, string, bool) {
+	for i, tier := range t {
+		if b, ok := tier.Cache.Get(key); ok {
+			for _, faster := range t[:i] {
+				faster.Cache.Set(key, b)
+			}
+			return b, tier.Name, true
+		}
+	}
+	return nil, "", false
+}
+
+// Set implements httpcache.Cache.
+func (t Tiers) Set(key string, resp []byte) {
+	if len(t) == 0 {
+		return
+	}
+	t[len(t)-1].Cache.Set(key, resp)
+	for _, faster := range t[:len(t)-1] {
+		faster.Cache.Delete(key)
+	}
+}
+
+// Delete implements httpcache.Cache.
+func (t Tiers) Delete(key string) {
+	for _, tier := range t {
+		tier.Cache.Delete(key)
+	}
+}
@@ -0,0 +1,59 @@
+package getcached
+
+import (
+	"testing"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/getcached/cachetest"
+)
+
+func TestTiers(t *testing.T) {
+	memory, disk, remote := httpcache.NewMemoryCache(), httpcache.NewMemoryCache(), httpcache.NewMemoryCache()
+	tiers := Tiers{{"memory", memory}, {"disk", disk}, {"remote", remote}}
+
+	memory.Set("key", []byte("stale"))
+	disk.Set("key", []byte("stale"))
+	tiers.Set("key", []byte("value"))
+	for _, tier := range tiers {
+		_, ok := tier.Cache.Get("key")
+		if want := tier.Name == "remote"; ok != want {
+			t.Errorf("unexpected presence of key '%s' in tier %q once set: got %t, want %t", "key", tier.Name, ok, want)
+		}
+	}
+
+	for _, want := range []string{"remote", "memory", "memory"} {
+		b, tier, ok := tiers.Locate("key")
+		if !ok || tier != want || string(b) != "value" {
+			t.Errorf("unexpected tier: got %q (%q), want %q", tier, b, want)
+		}
+	}
+	for _, tier := range tiers {
+		if b, ok := tier.Cache.Get("key"); !ok || string(b) != "value" {
+			t.Errorf("unexpected value of key '%s' in tier %q once located: got %q (%t), want %q", "key", tier.Name, b, ok, "value")
+		}
+	}
+
+	memory.Delete("key")
+	if _, tier, _ := tiers.Locate("key"); tier != "disk" {
+		t.Errorf("unexpected tier: got %q, want %q", tier, "disk")
+	}
+	if _, ok := memory.Get("key"); !ok {
+		t.Errorf("expected key '%s' to be copied back to tier %q", "key", "memory")
+	}
+
+	tiers.Delete("key")
+	for _, tier := range tiers {
+		if _, ok := tier.Cache.Get("key"); ok {
+			t.Errorf("unexpected key '%s' in tier %q", "key", tier.Name)
+		}
+	}
+	if _, tier, ok := tiers.Locate("key"); ok {
+		t.Errorf("unexpected tier: got %q, want none", tier)
+	}
+}
+
+func TestTiersConformance(t *testing.T) {
+	cachetest.RunConformance(t, func() httpcache.Cache {
+		return Tiers{{"memory", httpcache.NewMemoryCache()}, {"disk", httpcache.NewMemoryCache()}}
+	})
+}
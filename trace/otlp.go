@@ -0,0 +1,255 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEndpoint is the traces endpoint of an OTLP/HTTP
+	// collector running locally.
+	DefaultEndpoint = "http://localhost:4318/v1/traces"
+
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+	defaultMaxQueued     = 4096
+)
+
+// OTLPExporter is an Exporter sending spans in batches to
+// a collector speaking OTLP over HTTP, in its JSON encoding.
+// Spans are dropped when the collector can't keep up.
+type OTLPExporter struct {
+	endpoint  string
+	service   string
+	client    *http.Client
+	batch     int
+	interval  time.Duration
+	mu        sync.Mutex // guards spans
+	spans     []*Span
+	flush     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewOTLPExporter creates an OTLPExporter sending spans to
+// the traces endpoint of a collector, such as DefaultEndpoint.
+func NewOTLPExporter(endpoint string, options ...func(*OTLPExporter)) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		service:  "getcached",
+		client:   &http.Client{Timeout: 10 * time.Second},
+		batch:    defaultBatchSize,
+		interval: defaultFlushInterval,
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(e)
+	}
+
+	e.wg.Add(1)
+	go e.run()
+
+	return e
+}
+
+// WithServiceName configures the service name an OTLPExporter
+// reports spans for.
+func WithServiceName(name string) func(*OTLPExporter) {
+	return func(e *OTLPExporter) {
+		e.service = name
+	}
+}
+
+// WithBatch configures an OTLPExporter to send spans once
+// size of them are pending, or every interval.
+func WithBatch(size int, interval time.Duration) func(*OTLPExporter) {
+	return func(e *OTLPExporter) {
+		e.batch = size
+		e.interval = interval
+	}
+}
+
+// WithClient configures an OTLPExporter to use a specific
+// http.Client.
+func WithClient(client *http.Client) func(*OTLPExporter) {
+	return func(e *OTLPExporter) {
+		e.client = client
+	}
+}
+
+// Export implements Exporter.
+func (e *OTLPExporter) Export(s *Span) {
+	e.mu.Lock()
+	if len(e.spans) < defaultMaxQueued {
+		e.spans = append(e.spans, s)
+	}
+	full := len(e.spans) >= e.batch
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close sends the pending spans and stops the exporter.
+func (e *OTLPExporter) Close() error {
+	e.closeOnce.Do(func() { close(e.done) })
+	e.wg.Wait()
+	return nil
+}
+
+func (e *OTLPExporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flush:
+		case <-e.done:
+			e.send()
+			return
+		}
+		e.send()
+	}
+}
+
+// send posts the pending spans to the collector.
+func (e *OTLPExporter) send() error {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	res, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("trace: collector replied %s", res.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding of an ExportTraceServiceRequest.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []spanData `json:"spans"`
+	}
+
+	scope struct {
+		Name string `json:"name"`
+	}
+
+	spanData struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              Kind       `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            status     `json:"status"`
+	}
+
+	status struct {
+		Code    int    `json:"code,omitempty"` // 2 for errors
+		Message string `json:"message,omitempty"`
+	}
+
+	keyValue struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+func (e *OTLPExporter) request(spans []*Span) exportRequest {
+	data := make([]spanData, len(spans))
+	for i, s := range spans {
+		data[i] = spanData{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+		}
+		if s.Parent.IsValid() {
+			data[i].ParentSpanID = s.Parent.String()
+		}
+		if s.Err != nil {
+			data[i].Status = status{Code: 2, Message: s.Err.Error()}
+		}
+	}
+
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: attributes(map[string]interface{}{"service.name": e.service})},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "github.com/mikegleasonjr/getcached/trace"}, Spans: data}},
+	}}}
+}
+
+// attributes encodes attributes as OTLP AnyValues.
+func attributes(attrs map[string]interface{}) []keyValue {
+	kvs := make([]keyValue, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, keyValue{Key: k, Value: value})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs
+}
@@ -0,0 +1,103 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	collector := newCollector()
+	defer collector.Close()
+
+	exp := NewOTLPExporter(collector.URL+"/v1/traces", WithServiceName("test"), WithBatch(2, time.Hour))
+	tracer := New(WithExporter(exp))
+
+	ctx, server := tracer.Start(context.Background(), "server", Server)
+	server.SetAttribute("http.status_code", 200)
+	_, client := StartChild(ctx, "client", Client)
+	client.SetAttribute("cache.hit", false)
+	client.Finish(errors.New("failed"))
+	server.Finish(nil)
+	for deadline := time.Now().Add(time.Second); collector.count() == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	_, other := tracer.Start(context.Background(), "other", Internal)
+	other.Finish(nil)
+	exp.Close()
+
+	if got, want := collector.count(), 2; got != want {
+		t.Fatalf("unexpected requests: got %d, want %d", got, want)
+	}
+
+	rs := collector.requests[0].ResourceSpans[0]
+	if got, want := rs.Resource.Attributes[0].Value["stringValue"], "test"; got != want {
+		t.Errorf("unexpected service name: got %v, want %v", got, want)
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+	if got, want := len(spans), 2; got != want {
+		t.Fatalf("unexpected spans: got %d, want %d", got, want)
+	}
+	c, s := spans[0], spans[1]
+	if c.Name != "client" || c.Kind != Client || c.ParentSpanID != s.SpanID || c.TraceID != s.TraceID {
+		t.Errorf("unexpected client span: %+v", c)
+	}
+	if c.Status.Code != 2 || c.Status.Message != "failed" {
+		t.Errorf("unexpected client status: %+v", c.Status)
+	}
+	if got, want := c.Attributes[0].Value["boolValue"], false; got != want {
+		t.Errorf("unexpected attribute: got %v, want %v", got, want)
+	}
+	if s.Name != "server" || s.Kind != Server || s.ParentSpanID != "" || s.Status.Code != 0 {
+		t.Errorf("unexpected server span: %+v", s)
+	}
+	if got, want := s.Attributes[0].Value["intValue"], "200"; got != want {
+		t.Errorf("unexpected attribute: got %v, want %v", got, want)
+	}
+	if s.StartTimeUnixNano == "" || s.EndTimeUnixNano < s.StartTimeUnixNano {
+		t.Errorf("unexpected span times: %s to %s", s.StartTimeUnixNano, s.EndTimeUnixNano)
+	}
+
+	if got, want := collector.requests[1].ResourceSpans[0].ScopeSpans[0].Spans[0].Name, "other"; got != want {
+		t.Errorf("unexpected span flushed on close: got %s, want %s", got, want)
+	}
+}
+
+// collector is an OTLP/HTTP collector stub.
+type collector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []exportRequest
+}
+
+func newCollector() *collector {
+	c := new(collector)
+	c.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.URL.Path != "/v1/traces" || req.Header.Get("Content-Type") != "application/json" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var r exportRequest
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		c.requests = append(c.requests, r)
+		c.mu.Unlock()
+		rw.Write([]byte("{}"))
+	}))
+	return c
+}
+
+func (c *collector) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.requests)
+}
@@ -0,0 +1,266 @@
+// Package trace records the spans of the requests going
+// through clients and proxies and propagates their context
+// in the W3C Trace Context traceparent header, so that they
+// can be followed across services. Spans are sent to an
+// Exporter, such as an OTLP collector.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	mrand "math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header is the header propagating the context of spans.
+const Header = "Traceparent"
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span.
+type SpanID [8]byte
+
+// IsValid tells if the id is not zero.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid tells if the id is not zero.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// String returns the id in lowercase hex.
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// String returns the id in lowercase hex.
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the part of a span propagated to other
+// services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool // whether spans are recorded
+}
+
+// IsValid tells if the context identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// String returns the context as a traceparent header value.
+func (sc SpanContext) String() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Parse parses a traceparent header value.
+func Parse(traceparent string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+
+	var version, flags [1]byte
+	if !decode(version[:], parts[0]) || !decode(sc.TraceID[:], parts[1]) || !decode(sc.SpanID[:], parts[2]) || !decode(flags[:], parts[3]) {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// decode decodes lowercase hex filling b exactly.
+func decode(b []byte, s string) bool {
+	if len(s) != 2*len(b) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(b, []byte(s))
+	return err == nil
+}
+
+// Kind is the role of a span in a request.
+type Kind int
+
+// Kinds of spans, numbered as in OTLP.
+const (
+	Internal Kind = 1 // operation within a service
+	Server   Kind = 2 // request handled by a service
+	Client   Kind = 3 // request made to another service
+)
+
+// Span is an operation of a trace. The methods of a nil
+// Span do nothing, so that untraced requests need no checks.
+type Span struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID // zero for the root span of a trace
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Err        error
+
+	tracer *Tracer
+}
+
+// SetAttribute records a property of the operation. Values
+// are strings, bools, integers or floats.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// Finish ends the span, failed with err if not nil, and
+// exports it if sampled.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	s.Err = err
+	if s.Context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// Exporter sends finished spans to a tracing backend. It
+// must be safe for concurrent use.
+type Exporter interface {
+	Export(s *Span)
+}
+
+// Tracer starts spans. It is safe for concurrent use.
+type Tracer struct {
+	exporter Exporter
+	sampling float64 // fraction of the traces started recorded
+	mu       sync.Mutex
+	rand     *mrand.Rand
+}
+
+// New creates a Tracer.
+func New(options ...func(*Tracer)) *Tracer {
+	var seed [8]byte
+	rand.Read(seed[:])
+
+	t := &Tracer{
+		sampling: 1,
+		rand:     mrand.New(mrand.NewSource(int64(binary.LittleEndian.Uint64(seed[:])))),
+	}
+
+	for _, option := range options {
+		option(t)
+	}
+
+	return t
+}
+
+// WithExporter configures a Tracer to send the spans
+// it records to an Exporter.
+func WithExporter(e Exporter) func(*Tracer) {
+	return func(t *Tracer) {
+		t.exporter = e
+	}
+}
+
+// WithSampling configures the fraction of the traces started
+// by a Tracer which are recorded, between 0 and 1. Traces
+// continued from other services follow their decision.
+func WithSampling(rate float64) func(*Tracer) {
+	return func(t *Tracer) {
+		t.sampling = rate
+	}
+}
+
+// Start starts a span, child of the span of ctx or of the
+// remote span it holds, if any. The returned context holds
+// the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+		tracer:     t,
+	}
+
+	if parent, ok := spanContext(ctx); ok {
+		s.Context.TraceID = parent.TraceID
+		s.Context.Sampled = parent.Sampled
+		s.Parent = parent.SpanID
+	} else {
+		t.mu.Lock()
+		t.rand.Read(s.Context.TraceID[:])
+		s.Context.Sampled = t.rand.Float64() < t.sampling
+		t.mu.Unlock()
+	}
+
+	t.mu.Lock()
+	t.rand.Read(s.Context.SpanID[:])
+	t.mu.Unlock()
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+type (
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+// FromContext returns the span held by ctx, if any.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// StartChild starts a span, child of the span of ctx, with
+// the Tracer of its parent. It returns a nil Span if ctx
+// holds none.
+func StartChild(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// Extract returns a copy of ctx holding the remote span
+// propagated in h, if any, as the parent of the next span
+// started.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, ok := Parse(h.Get(Header))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject propagates the span of ctx, or the remote span it
+// holds, in h.
+func Inject(ctx context.Context, h http.Header) {
+	if sc, ok := spanContext(ctx); ok {
+		h.Set(Header, sc.String())
+	}
+}
+
+// spanContext returns the context of the span of ctx, or of
+// the remote span it holds.
+func spanContext(ctx context.Context) (SpanContext, bool) {
+	if s := FromContext(ctx); s != nil {
+		return s.Context, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
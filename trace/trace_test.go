@@ -0,0 +1,113 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, false},
+		{"garbage", false, false},
+		{"", false, false},
+	}
+
+	for _, test := range tests {
+		sc, ok := Parse(test.header)
+		if ok != test.ok || sc.Sampled != test.sampled {
+			t.Errorf("unexpected parsing of %q: got %v/%v, want %v/%v", test.header, ok, sc.Sampled, test.ok, test.sampled)
+		}
+		if ok && test.header[:2] == "00" && sc.String() != test.header {
+			t.Errorf("unexpected formatting: got %q, want %q", sc.String(), test.header)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	exp := new(recorder)
+	tracer := New(WithExporter(exp))
+
+	in := http.Header{Header: {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	ctx, server := tracer.Start(Extract(context.Background(), in), "server", Server)
+	ctx, client := StartChild(ctx, "client", Client)
+
+	out := http.Header{}
+	Inject(ctx, out)
+	client.Finish(nil)
+	server.Finish(nil)
+
+	if got, want := server.Context.TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736"; got != want {
+		t.Errorf("unexpected trace id: got %s, want %s", got, want)
+	}
+	if got, want := server.Parent.String(), "00f067aa0ba902b7"; got != want {
+		t.Errorf("unexpected parent: got %s, want %s", got, want)
+	}
+	if client.Parent != server.Context.SpanID || client.Context.TraceID != server.Context.TraceID {
+		t.Errorf("unexpected client span: got %+v, want child of %+v", client.Context, server.Context)
+	}
+	if got, want := out.Get(Header), client.Context.String(); got != want {
+		t.Errorf("unexpected %s header: got %q, want %q", Header, got, want)
+	}
+	if got, want := len(exp.spans), 2; got != want {
+		t.Errorf("unexpected exported spans: got %d, want %d", got, want)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	exp := new(recorder)
+	tracer := New(WithExporter(exp), WithSampling(0))
+
+	ctx, s := tracer.Start(context.Background(), "root", Server)
+	s.Finish(nil)
+	if s.Context.Sampled || len(exp.spans) != 0 {
+		t.Errorf("unexpected sampled span: %+v", s.Context)
+	}
+
+	// propagated even if not recorded
+	out := http.Header{}
+	Inject(ctx, out)
+	if got, want := out.Get(Header), s.Context.String(); got != want {
+		t.Errorf("unexpected %s header: got %q, want %q", Header, got, want)
+	}
+
+	// remote decisions win
+	in := http.Header{Header: {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	_, s = tracer.Start(Extract(context.Background(), in), "server", Server)
+	if !s.Context.Sampled {
+		t.Errorf("unexpected unsampled span continuing a sampled trace")
+	}
+}
+
+func TestStartChildUntraced(t *testing.T) {
+	_, s := StartChild(context.Background(), "child", Internal)
+	if s != nil {
+		t.Errorf("unexpected span: %+v", s)
+	}
+	s.SetAttribute("key", "value")
+	s.Finish(nil)
+}
+
+type recorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (r *recorder) Export(s *Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
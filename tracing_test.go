@@ -0,0 +1,112 @@
+package getcached
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mikegleasonjr/getcached/shard"
+	"github.com/mikegleasonjr/getcached/trace"
+)
+
+func TestTracing(t *testing.T) {
+	var traceparent string
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		traceparent = req.Header.Get(trace.Header)
+		rw.Header().Set("Cache-Control", "max-age=3600")
+		rw.Write([]byte("content"))
+	}))
+	defer origin.Close()
+
+	spans := new(spanRecorder)
+	proxy := httptest.NewServer(New(WithTracer(trace.New(trace.WithExporter(spans)))))
+	defer proxy.Close()
+
+	picker := shard.New()
+	picker.Set(proxy.URL)
+	client := &http.Client{Transport: NewClient(WithPicker(picker), WithClientTracer(trace.New(trace.WithExporter(spans))))}
+
+	res, err := client.Get(origin.URL + "/resource")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	// the proxy span ends once the response is sent
+	for deadline := time.Now().Add(time.Second); spans.byName()["getcached.proxy"] == nil && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	byName := spans.byName()
+	c, p, o := byName["getcached.client"], byName["getcached.proxy"], byName["origin"]
+	if c == nil || p == nil || o == nil || byName["cache.get"] == nil {
+		t.Fatalf("missing spans: got %v", byName)
+	}
+
+	if p.Parent != c.Context.SpanID || p.Context.TraceID != c.Context.TraceID {
+		t.Errorf("unexpected proxy span parent: got %s, want %s", p.Parent, c.Context.SpanID)
+	}
+	for _, name := range []string{"cache.get", "origin"} {
+		if got, want := byName[name].Parent, p.Context.SpanID; got != want {
+			t.Errorf("unexpected %s span parent: got %s, want %s", name, got, want)
+		}
+	}
+	if got, want := traceparent, o.Context.String(); got != want {
+		t.Errorf("unexpected traceparent at origin: got %q, want %q", got, want)
+	}
+	if got, want := p.Attributes["cache.status"], string(StatusMiss); got != want {
+		t.Errorf("unexpected cache status: got %v, want %v", got, want)
+	}
+	if got, want := o.Attributes["http.status_code"], http.StatusOK; got != want {
+		t.Errorf("unexpected origin status: got %v, want %v", got, want)
+	}
+}
+
+func TestClientPropagation(t *testing.T) {
+	var traceparent string
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		traceparent = req.Header.Get(trace.Header)
+	}))
+	defer proxy.Close()
+
+	picker := shard.New()
+	picker.Set(proxy.URL)
+	client := NewClient(WithPicker(picker))
+
+	ctx, span := trace.New().Start(httptest.NewRequest("GET", "/", nil).Context(), "caller", trace.Internal)
+	req, _ := http.NewRequest("GET", "http://origin.net/resource", nil)
+	res, err := client.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	res.Body.Close()
+
+	if got, want := traceparent, span.Context.String(); got != want {
+		t.Errorf("unexpected traceparent: got %q, want %q", got, want)
+	}
+}
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.Span
+}
+
+func (r *spanRecorder) Export(s *trace.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+func (r *spanRecorder) byName() map[string]*trace.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := map[string]*trace.Span{}
+	for _, s := range r.spans {
+		m[s.Name] = s
+	}
+	return m
+}
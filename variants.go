@@ -0,0 +1,220 @@
+package getcached
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gregjones/httpcache"
+)
+
+const (
+	defaultMaxVariants = 8
+	indexHeader        = "getcached-variants/1\n"
+)
+
+// varier is an http.RoundTripper letting httpcache store multiple
+// representations of a resource, one per combination of the
+// request headers listed in the Vary response header.
+type varier struct {
+	tr  *httpcache.Transport
+	max int        // max variants per resource
+	mu  sync.Mutex // guards variant indexes
+}
+
+// RoundTrip implements http.RoundTripper.
+func (v *varier) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := *v.tr
+	t.Cache = &variants{v: v, c: cacheFor(req.Context(), v.tr.Cache), req: req}
+	return t.RoundTrip(req)
+}
+
+// variants is the httpcache.Cache seen by httpcache for a single
+// request. A response varying on some request headers is stored
+// under a secondary key derived from the values of those headers,
+// while its primary key holds an index of the variants.
+type variants struct {
+	v   *varier
+	c   httpcache.Cache
+	req *http.Request
+}
+
+type index struct {
+	vary []string // canonical header names
+	keys []string // secondary keys, most recent first
+}
+
+// Get implements httpcache.Cache.
+func (s *variants) Get(key string) ([]byte, bool) {
+	b, ok := s.c.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	idx, isIndex := parseIndex(b)
+	if !isIndex {
+		return b, true
+	}
+
+	return s.c.Get(secondaryKey(key, idx.vary, s.req.Header))
+}
+
+// Set implements httpcache.Cache.
+func (s *variants) Set(key string, resp []byte) {
+	vary, ok := varyOf(resp)
+	if !ok {
+		s.Delete(key)
+		return
+	}
+
+	s.v.mu.Lock()
+	defer s.v.mu.Unlock()
+
+	var idx index
+	if b, exists := s.c.Get(key); exists {
+		idx, _ = parseIndex(b)
+	}
+
+	if len(vary) == 0 {
+		s.deleteAll(idx)
+		s.c.Set(key, resp)
+		return
+	}
+
+	if !equalFold(idx.vary, vary) {
+		s.deleteAll(idx)
+		idx = index{vary: vary}
+	}
+
+	secondary := secondaryKey(key, vary, s.req.Header)
+	keys := []string{secondary}
+	for _, k := range idx.keys {
+		if k == secondary {
+			continue
+		}
+		if len(keys) < s.v.max {
+			keys = append(keys, k)
+		} else {
+			s.c.Delete(k)
+		}
+	}
+	idx.keys = keys
+
+	s.c.Set(secondary, resp)
+	s.c.Set(key, idx.bytes())
+}
+
+// Delete implements httpcache.Cache. Unsafe requests invalidate
+// all the variants of a resource, safe ones only their own.
+func (s *variants) Delete(key string) {
+	s.v.mu.Lock()
+	defer s.v.mu.Unlock()
+
+	b, exists := s.c.Get(key)
+	if !exists {
+		return
+	}
+
+	idx, isIndex := parseIndex(b)
+	if !isIndex || (s.req.Method != http.MethodGet && s.req.Method != http.MethodHead) {
+		s.deleteAll(idx)
+		s.c.Delete(key)
+		return
+	}
+
+	secondary := secondaryKey(key, idx.vary, s.req.Header)
+	keys := idx.keys[:0]
+	for _, k := range idx.keys {
+		if k != secondary {
+			keys = append(keys, k)
+		}
+	}
+	idx.keys = keys
+
+	s.c.Delete(secondary)
+	if len(idx.keys) == 0 {
+		s.c.Delete(key)
+	} else {
+		s.c.Set(key, idx.bytes())
+	}
+}
+
+func (s *variants) deleteAll(idx index) {
+	for _, k := range idx.keys {
+		s.c.Delete(k)
+	}
+}
+
+func (idx index) bytes() []byte {
+	return []byte(indexHeader + strings.Join(idx.vary, ",") + "\n" + strings.Join(idx.keys, "\n"))
+}
+
+func parseIndex(b []byte) (idx index, ok bool) {
+	if !bytes.HasPrefix(b, []byte(indexHeader)) {
+		return
+	}
+
+	lines := strings.Split(string(b[len(indexHeader):]), "\n")
+	idx.vary = strings.Split(lines[0], ",")
+	for _, k := range lines[1:] {
+		if k != "" {
+			idx.keys = append(idx.keys, k)
+		}
+	}
+
+	return idx, true
+}
+
+// varyOf returns the canonical header names a serialized response
+// varies on. It returns false if the response can't be stored.
+func varyOf(resp []byte) ([]string, bool) {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+	if err != nil {
+		return nil, true // not for us to judge
+	}
+
+	var vary []string
+	for _, v := range res.Header["Vary"] {
+		for _, field := range strings.Split(v, ",") {
+			field = http.CanonicalHeaderKey(strings.TrimSpace(field))
+			if field == "*" {
+				return nil, false
+			}
+			if field != "" {
+				vary = append(vary, field)
+			}
+		}
+	}
+
+	return vary, true
+}
+
+func secondaryKey(key string, vary []string, h http.Header) string {
+	values := url.Values{}
+	for _, field := range vary {
+		values.Set(strings.ToLower(field), h.Get(field))
+	}
+	return variantKey(key, values)
+}
+
+// variantKey returns the cache key of a variant of the response
+// cached as key. Spaces are escaped in URLs so variant keys
+// can't collide with them.
+func variantKey(key string, values url.Values) string {
+	return key + " " + values.Encode()
+}
+
+func equalFold(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}